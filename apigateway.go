@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	apigatewaytypes "github.com/aws/aws-sdk-go-v2/service/apigateway/types"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	apigatewayv2types "github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func apiGatewayHttpVpcLinkIds(vpcLinks []apigatewayv2types.VpcLink) []string {
+	vpcLinkIds := make([]string, 0, len(vpcLinks))
+	for _, vpcLink := range vpcLinks {
+		if vpcLink.VpcLinkId != nil {
+			vpcLinkIds = append(vpcLinkIds, *vpcLink.VpcLinkId)
+		}
+	}
+	return vpcLinkIds
+}
+
+func apiGatewayRestVpcLinkIds(vpcLinks []apigatewaytypes.VpcLink) []string {
+	vpcLinkIds := make([]string, 0, len(vpcLinks))
+	for _, vpcLink := range vpcLinks {
+		if vpcLink.Id != nil {
+			vpcLinkIds = append(vpcLinkIds, *vpcLink.Id)
+		}
+	}
+	return vpcLinkIds
+}
+
+// deleteApiGatewayHttpVpcLinks deletes the API Gateway HTTP API VPC links
+// vpcLinks and waits for them to be deleted.
+func deleteApiGatewayHttpVpcLinks(ctx context.Context, client *apigatewayv2.Client, vpcLinks []apigatewayv2types.VpcLink) error {
+	var errs error
+	deletingVpcLinkIds := newStringSet()
+	for _, vpcLink := range vpcLinks {
+		if vpcLink.VpcLinkId == nil {
+			continue
+		}
+		if vpcLink.VpcLinkStatus == apigatewayv2types.VpcLinkStatusDeleting {
+			deletingVpcLinkIds[*vpcLink.VpcLinkId] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteVpcLink(ctx, &apigatewayv2.DeleteVpcLinkInput{
+			VpcLinkId: vpcLink.VpcLinkId,
+		})
+		log.Err(err).
+			Str("VpcLinkId", *vpcLink.VpcLinkId).
+			Msg("DeleteVpcLink")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingVpcLinkIds[*vpcLink.VpcLinkId] = struct{}{}
+		}
+	}
+	if len(deletingVpcLinkIds) == 0 {
+		return errs
+	}
+
+	// Wait for the VPC links to be deleted.
+	var remaining []string
+	err := poll(ctx, apiGatewayVpcLinkPollInterval, apiGatewayVpcLinkDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		vpcLinks, err := listApiGatewayHttpVpcLinks(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, vpcLinkId := range apiGatewayHttpVpcLinkIds(vpcLinks) {
+			if deletingVpcLinkIds.contains(vpcLinkId) {
+				remaining = append(remaining, vpcLinkId)
+			}
+		}
+		log.Info().
+			Strs("VpcLinkIds", remaining).
+			Msg("GetVpcLinks")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for API Gateway HTTP API VPC links to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteApiGatewayRestVpcLinks deletes the API Gateway REST API VPC links
+// vpcLinks and waits for them to be deleted.
+func deleteApiGatewayRestVpcLinks(ctx context.Context, client *apigateway.Client, vpcLinks []apigatewaytypes.VpcLink) error {
+	var errs error
+	deletingVpcLinkIds := newStringSet()
+	for _, vpcLink := range vpcLinks {
+		if vpcLink.Id == nil {
+			continue
+		}
+		if vpcLink.Status == apigatewaytypes.VpcLinkStatusDeleting {
+			deletingVpcLinkIds[*vpcLink.Id] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteVpcLink(ctx, &apigateway.DeleteVpcLinkInput{
+			VpcLinkId: vpcLink.Id,
+		})
+		log.Err(err).
+			Str("VpcLinkId", *vpcLink.Id).
+			Msg("DeleteVpcLink")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingVpcLinkIds[*vpcLink.Id] = struct{}{}
+		}
+	}
+	if len(deletingVpcLinkIds) == 0 {
+		return errs
+	}
+
+	// Wait for the VPC links to be deleted.
+	var remaining []string
+	err := poll(ctx, apiGatewayVpcLinkPollInterval, apiGatewayVpcLinkDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		vpcLinks, err := listApiGatewayRestVpcLinks(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, vpcLinkId := range apiGatewayRestVpcLinkIds(vpcLinks) {
+			if deletingVpcLinkIds.contains(vpcLinkId) {
+				remaining = append(remaining, vpcLinkId)
+			}
+		}
+		log.Info().
+			Strs("VpcLinkIds", remaining).
+			Msg("GetVpcLinks")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for API Gateway REST API VPC links to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteApiGatewayVpcLinksInVpc deletes the API Gateway REST API VPC links
+// that target Network LoadBalancers in the VPC vpcId and the HTTP API VPC
+// links with subnets in the VPC, and waits for them to be deleted. It then
+// records the HTTP API VPC links' requester-managed NetworkInterfaces in opts,
+// to be waited for once all other services have been deleted. It accumulates
+// errors.
+func deleteApiGatewayVpcLinksInVpc(ctx context.Context, client *apigateway.Client, v2Client *apigatewayv2.Client, elbv2Client *elasticloadbalancingv2.Client, ec2Client *ec2.Client, vpcId string, opts *options) (errs error) {
+	if loadBalancers, err := listLoadBalancersV2(ctx, elbv2Client, vpcId); err != nil {
+		log.Err(err).
+			Msg("listLoadBalancersV2")
+		errs = multierr.Append(errs, err)
+	} else if restVpcLinks, err := listApiGatewayRestVpcLinks(ctx, client, newStringSet(loadBalancerV2Arns(loadBalancers)...)); err != nil {
+		log.Err(err).
+			Msg("listApiGatewayRestVpcLinks")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("vpcLinkIds", apiGatewayRestVpcLinkIds(restVpcLinks)).
+			Msg("listApiGatewayRestVpcLinks")
+		if len(restVpcLinks) > 0 {
+			err := deleteApiGatewayRestVpcLinks(ctx, client, restVpcLinks)
+			log.Err(err).
+				Strs("vpcLinkIds", apiGatewayRestVpcLinkIds(restVpcLinks)).
+				Msg("deleteApiGatewayRestVpcLinks")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return multierr.Append(errs, err)
+	}
+
+	httpVpcLinks, err := listApiGatewayHttpVpcLinks(ctx, v2Client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listApiGatewayHttpVpcLinks")
+		return multierr.Append(errs, err)
+	}
+	log.Info().
+		Strs("vpcLinkIds", apiGatewayHttpVpcLinkIds(httpVpcLinks)).
+		Msg("listApiGatewayHttpVpcLinks")
+	if len(httpVpcLinks) == 0 {
+		return
+	}
+
+	err = deleteApiGatewayHttpVpcLinks(ctx, v2Client, httpVpcLinks)
+	log.Err(err).
+		Strs("vpcLinkIds", apiGatewayHttpVpcLinkIds(httpVpcLinks)).
+		Msg("deleteApiGatewayHttpVpcLinks")
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+
+	// Record the requester-managed NetworkInterfaces in the HTTP API VPC
+	// links' subnets and security groups.
+	var vpcLinkSubnetIds, vpcLinkSecurityGroupIds []string
+	for _, vpcLink := range httpVpcLinks {
+		vpcLinkSubnetIds = append(vpcLinkSubnetIds, vpcLink.SubnetIds...)
+		vpcLinkSecurityGroupIds = append(vpcLinkSecurityGroupIds, vpcLink.SecurityGroupIds...)
+	}
+	filters := append(ec2VpcFilter(vpcId),
+		ec2types.Filter{
+			Name:   aws.String("requester-managed"),
+			Values: []string{"true"},
+		},
+		ec2types.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: vpcLinkSubnetIds,
+		},
+	)
+	if len(vpcLinkSecurityGroupIds) > 0 {
+		filters = append(filters, ec2types.Filter{
+			Name:   aws.String("group-id"),
+			Values: vpcLinkSecurityGroupIds,
+		})
+	}
+	err = addPendingNetworkInterfaces(ctx, ec2Client, filters, apiGatewayVpcLinkDeletedWaiterMaxDuration, opts)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("addPendingNetworkInterfaces")
+	return multierr.Append(errs, err)
+}
+
+// listApiGatewayHttpVpcLinks returns the API Gateway HTTP API VPC links with a
+// subnet in subnetIds, or all VPC links if subnetIds is nil.
+func listApiGatewayHttpVpcLinks(ctx context.Context, client *apigatewayv2.Client, subnetIds stringSet) ([]apigatewayv2types.VpcLink, error) {
+	input := apigatewayv2.GetVpcLinksInput{}
+	var vpcLinks []apigatewayv2types.VpcLink
+	for {
+		output, err := client.GetVpcLinks(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	VPC_LINK:
+		for _, vpcLink := range output.Items {
+			if subnetIds == nil {
+				vpcLinks = append(vpcLinks, vpcLink)
+				continue
+			}
+			for _, subnetId := range vpcLink.SubnetIds {
+				if subnetIds.contains(subnetId) {
+					vpcLinks = append(vpcLinks, vpcLink)
+					continue VPC_LINK
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return vpcLinks, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listApiGatewayRestVpcLinks returns the API Gateway REST API VPC links that
+// target one of loadBalancerArns, or all VPC links if loadBalancerArns is nil.
+func listApiGatewayRestVpcLinks(ctx context.Context, client *apigateway.Client, loadBalancerArns stringSet) ([]apigatewaytypes.VpcLink, error) {
+	input := apigateway.GetVpcLinksInput{}
+	var vpcLinks []apigatewaytypes.VpcLink
+	for {
+		output, err := client.GetVpcLinks(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	VPC_LINK:
+		for _, vpcLink := range output.Items {
+			if loadBalancerArns == nil {
+				vpcLinks = append(vpcLinks, vpcLink)
+				continue
+			}
+			for _, targetArn := range vpcLink.TargetArns {
+				if loadBalancerArns.contains(targetArn) {
+					vpcLinks = append(vpcLinks, vpcLink)
+					continue VPC_LINK
+				}
+			}
+		}
+		if output.Position == nil {
+			return vpcLinks, nil
+		}
+		input.Position = output.Position
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func appRunnerVpcConnectorArns(vpcConnectors []types.VpcConnector) []string {
+	vpcConnectorArns := make([]string, 0, len(vpcConnectors))
+	for _, vpcConnector := range vpcConnectors {
+		if vpcConnector.VpcConnectorArn != nil {
+			vpcConnectorArns = append(vpcConnectorArns, *vpcConnector.VpcConnectorArn)
+		}
+	}
+	return vpcConnectorArns
+}
+
+func appRunnerServiceArns(services []*types.Service) []string {
+	serviceArns := make([]string, 0, len(services))
+	for _, service := range services {
+		if service.ServiceArn != nil {
+			serviceArns = append(serviceArns, *service.ServiceArn)
+		}
+	}
+	return serviceArns
+}
+
+func deleteAppRunnerVpcConnectors(ctx context.Context, client *apprunner.Client, vpcConnectors []types.VpcConnector) (errs error) {
+	for _, vpcConnector := range vpcConnectors {
+		_, err := client.DeleteVpcConnector(ctx, &apprunner.DeleteVpcConnectorInput{
+			VpcConnectorArn: vpcConnector.VpcConnectorArn,
+		})
+		log.Err(err).
+			Str("VpcConnectorArn", aws.ToString(vpcConnector.VpcConnectorArn)).
+			Msg("DeleteVpcConnector")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteAppRunnerVpcConnectorsInVpc deletes the App Runner VPC connectors with
+// subnets in the VPC vpcId and then records App Runner's requester-managed
+// NetworkInterfaces in opts, to be waited for once all other services have
+// been deleted. App Runner refuses to delete VPC connectors that are used by
+// services, so those services are reported as errors.
+func deleteAppRunnerVpcConnectorsInVpc(ctx context.Context, client *apprunner.Client, ec2Client *ec2.Client, vpcId string, opts *options) error {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+
+	vpcConnectors, err := listAppRunnerVpcConnectors(ctx, client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listAppRunnerVpcConnectors")
+		return err
+	}
+	log.Info().
+		Strs("vpcConnectorArns", appRunnerVpcConnectorArns(vpcConnectors)).
+		Msg("listAppRunnerVpcConnectors")
+	if len(vpcConnectors) == 0 {
+		return nil
+	}
+
+	services, err := listAppRunnerServices(ctx, client, newStringSet(appRunnerVpcConnectorArns(vpcConnectors)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listAppRunnerServices")
+		return err
+	}
+	log.Info().
+		Strs("serviceArns", appRunnerServiceArns(services)).
+		Msg("listAppRunnerServices")
+
+	// Only delete the VPC connectors that are not used by services.
+	var errs error
+	if len(services) > 0 {
+		errs = fmt.Errorf("App Runner services use VPC connectors in VPC %s, delete them first: %s", vpcId, strings.Join(appRunnerServiceArns(services), ", "))
+		usedVpcConnectorArns := newStringSet()
+		for _, service := range services {
+			usedVpcConnectorArns[*service.NetworkConfiguration.EgressConfiguration.VpcConnectorArn] = struct{}{}
+		}
+		var unusedVpcConnectors []types.VpcConnector
+		for _, vpcConnector := range vpcConnectors {
+			if !usedVpcConnectorArns.contains(aws.ToString(vpcConnector.VpcConnectorArn)) {
+				unusedVpcConnectors = append(unusedVpcConnectors, vpcConnector)
+			}
+		}
+		vpcConnectors = unusedVpcConnectors
+		if len(vpcConnectors) == 0 {
+			return errs
+		}
+	}
+
+	err = deleteAppRunnerVpcConnectors(ctx, client, vpcConnectors)
+	log.Err(err).
+		Strs("vpcConnectorArns", appRunnerVpcConnectorArns(vpcConnectors)).
+		Msg("deleteAppRunnerVpcConnectors")
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+
+	// Record the requester-managed NetworkInterfaces in the VPC connectors'
+	// subnets and security groups.
+	var vpcConnectorSubnetIds, vpcConnectorSecurityGroupIds []string
+	for _, vpcConnector := range vpcConnectors {
+		vpcConnectorSubnetIds = append(vpcConnectorSubnetIds, vpcConnector.Subnets...)
+		vpcConnectorSecurityGroupIds = append(vpcConnectorSecurityGroupIds, vpcConnector.SecurityGroups...)
+	}
+	filters := append(ec2VpcFilter(vpcId),
+		ec2types.Filter{
+			Name:   aws.String("requester-managed"),
+			Values: []string{"true"},
+		},
+		ec2types.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: vpcConnectorSubnetIds,
+		},
+	)
+	if len(vpcConnectorSecurityGroupIds) > 0 {
+		filters = append(filters, ec2types.Filter{
+			Name:   aws.String("group-id"),
+			Values: vpcConnectorSecurityGroupIds,
+		})
+	}
+	err = addPendingNetworkInterfaces(ctx, ec2Client, filters, appRunnerVpcConnectorDeletedWaiterMaxDuration, opts)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("addPendingNetworkInterfaces")
+	return multierr.Append(errs, err)
+}
+
+// listAppRunnerServices returns the App Runner services that have not been
+// deleted whose egress uses one of vpcConnectorArns.
+func listAppRunnerServices(ctx context.Context, client *apprunner.Client, vpcConnectorArns stringSet) ([]*types.Service, error) {
+	input := apprunner.ListServicesInput{}
+	var services []*types.Service
+	for {
+		output, err := client.ListServices(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, serviceSummary := range output.ServiceSummaryList {
+			if serviceSummary.Status == types.ServiceStatusDeleted {
+				continue
+			}
+			describeServiceOutput, err := client.DescribeService(ctx, &apprunner.DescribeServiceInput{
+				ServiceArn: serviceSummary.ServiceArn,
+			})
+			if err != nil {
+				var resourceNotFoundException *types.ResourceNotFoundException
+				if errors.As(err, &resourceNotFoundException) {
+					continue
+				}
+				return nil, err
+			}
+			service := describeServiceOutput.Service
+			if service == nil || service.NetworkConfiguration == nil || service.NetworkConfiguration.EgressConfiguration == nil {
+				continue
+			}
+			if vpcConnectorArn := service.NetworkConfiguration.EgressConfiguration.VpcConnectorArn; vpcConnectorArn != nil && vpcConnectorArns.contains(*vpcConnectorArn) {
+				services = append(services, service)
+			}
+		}
+		if output.NextToken == nil {
+			return services, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listAppRunnerVpcConnectors returns the active App Runner VPC connectors with
+// a subnet in subnetIds.
+func listAppRunnerVpcConnectors(ctx context.Context, client *apprunner.Client, subnetIds stringSet) ([]types.VpcConnector, error) {
+	input := apprunner.ListVpcConnectorsInput{}
+	var vpcConnectors []types.VpcConnector
+	for {
+		output, err := client.ListVpcConnectors(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	VPC_CONNECTOR:
+		for _, vpcConnector := range output.VpcConnectors {
+			if vpcConnector.Status != types.VpcConnectorStatusActive {
+				continue
+			}
+			for _, subnetId := range vpcConnector.Subnets {
+				if subnetIds.contains(subnetId) {
+					vpcConnectors = append(vpcConnectors, vpcConnector)
+					continue VPC_CONNECTOR
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return vpcConnectors, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/multierr"
 )
 
+// setInstanceProtectionMaxInstanceIds is the maximum number of Instance IDs
+// accepted by a single SetInstanceProtection call.
+const setInstanceProtectionMaxInstanceIds = 50
+
 func autoScalingGroupNames(autoScalingGroups []types.AutoScalingGroup) []string {
 	autoScalingGroupNames := make([]string, 0, len(autoScalingGroups))
 	for _, autoScalingGroup := range autoScalingGroups {
@@ -21,60 +26,164 @@ func autoScalingGroupNames(autoScalingGroups []types.AutoScalingGroup) []string
 	return autoScalingGroupNames
 }
 
-func deleteAutoScalingGroups(ctx context.Context, client *autoscaling.Client, ec2Client *ec2.Client, autoScalingGroups []types.AutoScalingGroup) (errs error) {
-	for _, autoScalingGroup := range autoScalingGroups {
-		if autoScalingGroup.AutoScalingGroupName == nil {
+// completeAutoScalingGroupLifecycleActions abandons the lifecycle actions of
+// the autoScalingGroup's Instances that are waiting on a lifecycle hook, and
+// then deletes its lifecycle hooks so that no new Instances wait on them.
+func completeAutoScalingGroupLifecycleActions(ctx context.Context, client *autoscaling.Client, autoScalingGroup types.AutoScalingGroup) (errs error) {
+	output, err := client.DescribeLifecycleHooks(ctx, &autoscaling.DescribeLifecycleHooksInput{
+		AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range autoScalingGroup.Instances {
+		if instance.InstanceId == nil {
 			continue
 		}
-
-		// Resize the AutoScalingGroup to zero if not already zero.
-		if (autoScalingGroup.DesiredCapacity != nil && *autoScalingGroup.DesiredCapacity != 0) ||
-			(autoScalingGroup.MaxSize != nil && *autoScalingGroup.MaxSize != 0) ||
-			(autoScalingGroup.MinSize != nil && *autoScalingGroup.MinSize != 0) {
-			_, err := client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
-				AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
-				DesiredCapacity:      aws.Int32(0),
-				MaxSize:              aws.Int32(0),
-				MinSize:              aws.Int32(0),
+		var lifecycleTransition string
+		switch instance.LifecycleState {
+		case types.LifecycleStatePendingWait:
+			lifecycleTransition = "autoscaling:EC2_INSTANCE_LAUNCHING"
+		case types.LifecycleStateTerminatingWait:
+			lifecycleTransition = "autoscaling:EC2_INSTANCE_TERMINATING"
+		default:
+			continue
+		}
+		for _, lifecycleHook := range output.LifecycleHooks {
+			if lifecycleHook.LifecycleHookName == nil || aws.ToString(lifecycleHook.LifecycleTransition) != lifecycleTransition {
+				continue
+			}
+			_, err := client.CompleteLifecycleAction(ctx, &autoscaling.CompleteLifecycleActionInput{
+				AutoScalingGroupName:  autoScalingGroup.AutoScalingGroupName,
+				InstanceId:            instance.InstanceId,
+				LifecycleActionResult: aws.String("ABANDON"),
+				LifecycleHookName:     lifecycleHook.LifecycleHookName,
 			})
 			log.Err(err).
 				Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
-				Msg("UpdateAutoScalingGroup")
+				Str("InstanceId", *instance.InstanceId).
+				Str("LifecycleHookName", *lifecycleHook.LifecycleHookName).
+				Msg("CompleteLifecycleAction")
 			errs = multierr.Append(errs, err)
 		}
+	}
 
-		// Wait for any Instances to terminate.
-		instanceIds := make([]string, 0, len(autoScalingGroup.Instances))
-		for _, instance := range autoScalingGroup.Instances {
-			if instance.InstanceId != nil {
-				instanceIds = append(instanceIds, *instance.InstanceId)
-			}
-		}
-		if len(instanceIds) > 0 {
-			instanceTerminatedWaiter := ec2.NewInstanceTerminatedWaiter(ec2Client)
-			log.Info().
-				Strs("InstanceIds", instanceIds).
-				Msg("InstanceTerminatedWaiter.Wait")
-			err := instanceTerminatedWaiter.Wait(ctx, &ec2.DescribeInstancesInput{
-				InstanceIds: instanceIds,
-			}, instanceTerminatedWaiterMaxDuration)
-			log.Err(err).
-				Msg("InstanceTerminatedWaiter.Wait")
-			errs = multierr.Append(errs, err)
-			if err != nil {
-				continue
-			}
+	for _, lifecycleHook := range output.LifecycleHooks {
+		if lifecycleHook.LifecycleHookName == nil {
+			continue
 		}
+		_, err := client.DeleteLifecycleHook(ctx, &autoscaling.DeleteLifecycleHookInput{
+			AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
+			LifecycleHookName:    lifecycleHook.LifecycleHookName,
+		})
+		log.Err(err).
+			Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+			Str("LifecycleHookName", *lifecycleHook.LifecycleHookName).
+			Msg("DeleteLifecycleHook")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
 
-		// Delete the AutoScalingGroup.
-		_, err := client.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+// deleteAutoScalingGroup deletes autoScalingGroup and waits for it to be
+// deleted. Anything that would prevent its Instances from terminating (its warm
+// pool, lifecycle hooks, and scale-in protection) is removed first. If
+// forceDelete is true then the AutoScalingGroup is deleted without waiting for
+// its Instances to terminate.
+func deleteAutoScalingGroup(ctx context.Context, client *autoscaling.Client, autoScalingGroup types.AutoScalingGroup, forceDelete bool) error {
+	var errs error
+
+	// Delete the warm pool, including its Instances.
+	if autoScalingGroup.WarmPoolConfiguration != nil {
+		_, err := client.DeleteWarmPool(ctx, &autoscaling.DeleteWarmPoolInput{
 			AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
+			ForceDelete:          aws.Bool(true),
+		})
+		log.Err(err).
+			Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+			Msg("DeleteWarmPool")
+		errs = multierr.Append(errs, err)
+	}
+
+	// Release Instances waiting on lifecycle hooks and delete the hooks.
+	err := completeAutoScalingGroupLifecycleActions(ctx, client, autoScalingGroup)
+	log.Err(err).
+		Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+		Msg("completeAutoScalingGroupLifecycleActions")
+	errs = multierr.Append(errs, err)
+
+	// Remove scale-in protection from the Instances.
+	err = removeAutoScalingGroupInstanceProtection(ctx, client, autoScalingGroup)
+	log.Err(err).
+		Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+		Msg("removeAutoScalingGroupInstanceProtection")
+	errs = multierr.Append(errs, err)
+
+	// Resize the AutoScalingGroup to zero if not already zero.
+	if (autoScalingGroup.DesiredCapacity != nil && *autoScalingGroup.DesiredCapacity != 0) ||
+		(autoScalingGroup.MaxSize != nil && *autoScalingGroup.MaxSize != 0) ||
+		(autoScalingGroup.MinSize != nil && *autoScalingGroup.MinSize != 0) ||
+		aws.ToBool(autoScalingGroup.NewInstancesProtectedFromScaleIn) {
+		_, err := client.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName:             autoScalingGroup.AutoScalingGroupName,
+			DesiredCapacity:                  aws.Int32(0),
+			MaxSize:                          aws.Int32(0),
+			MinSize:                          aws.Int32(0),
+			NewInstancesProtectedFromScaleIn: aws.Bool(false),
 		})
 		log.Err(err).
 			Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
-			Msg("DeleteAutoScalingGroup")
+			Msg("UpdateAutoScalingGroup")
 		errs = multierr.Append(errs, err)
 	}
+
+	// Wait for any Instances to terminate.
+	if !forceDelete {
+		err := waitForAutoScalingGroupInstancesTerminated(ctx, client, *autoScalingGroup.AutoScalingGroupName)
+		log.Err(err).
+			Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+			Msg("waitForAutoScalingGroupInstancesTerminated")
+		if err != nil {
+			return multierr.Append(errs, err)
+		}
+	}
+
+	// Delete the AutoScalingGroup.
+	_, err = client.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
+		ForceDelete:          aws.Bool(forceDelete),
+	})
+	log.Err(err).
+		Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+		Bool("ForceDelete", forceDelete).
+		Msg("DeleteAutoScalingGroup")
+	if err != nil {
+		return multierr.Append(errs, err)
+	}
+
+	// Wait for the AutoScalingGroup to be deleted.
+	groupNotExistsWaiter := autoscaling.NewGroupNotExistsWaiter(client)
+	log.Info().
+		Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+		Msg("GroupNotExistsWaiter.Wait")
+	err = groupNotExistsWaiter.Wait(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{*autoScalingGroup.AutoScalingGroupName},
+	}, autoScalingGroupDeletedWaiterMaxDuration)
+	log.Err(err).
+		Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+		Msg("GroupNotExistsWaiter.Wait")
+	return multierr.Append(errs, err)
+}
+
+func deleteAutoScalingGroups(ctx context.Context, client *autoscaling.Client, autoScalingGroups []types.AutoScalingGroup, forceDelete bool) (errs error) {
+	for _, autoScalingGroup := range autoScalingGroups {
+		if autoScalingGroup.AutoScalingGroupName == nil {
+			continue
+		}
+		errs = multierr.Append(errs, deleteAutoScalingGroup(ctx, client, autoScalingGroup, forceDelete))
+	}
 	return
 }
 
@@ -95,3 +204,64 @@ func listAutoScalingGroups(ctx context.Context, client *autoscaling.Client, filt
 		input.NextToken = output.NextToken
 	}
 }
+
+// removeAutoScalingGroupInstanceProtection removes scale-in protection from the
+// autoScalingGroup's Instances so that they are terminated when it is resized
+// to zero.
+func removeAutoScalingGroupInstanceProtection(ctx context.Context, client *autoscaling.Client, autoScalingGroup types.AutoScalingGroup) (errs error) {
+	var instanceIds []string
+	for _, instance := range autoScalingGroup.Instances {
+		if instance.InstanceId != nil && aws.ToBool(instance.ProtectedFromScaleIn) {
+			instanceIds = append(instanceIds, *instance.InstanceId)
+		}
+	}
+	for start := 0; start < len(instanceIds); start += setInstanceProtectionMaxInstanceIds {
+		end := start + setInstanceProtectionMaxInstanceIds
+		if end > len(instanceIds) {
+			end = len(instanceIds)
+		}
+		_, err := client.SetInstanceProtection(ctx, &autoscaling.SetInstanceProtectionInput{
+			AutoScalingGroupName: autoScalingGroup.AutoScalingGroupName,
+			InstanceIds:          instanceIds[start:end],
+			ProtectedFromScaleIn: aws.Bool(false),
+		})
+		log.Err(err).
+			Str("AutoScalingGroupName", *autoScalingGroup.AutoScalingGroupName).
+			Strs("InstanceIds", instanceIds[start:end]).
+			Msg("SetInstanceProtection")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// waitForAutoScalingGroupInstancesTerminated waits for the AutoScalingGroup
+// autoScalingGroupName to have no Instances, for up to
+// autoScalingGroupDeletedWaiterMaxDuration.
+func waitForAutoScalingGroupInstancesTerminated(ctx context.Context, client *autoscaling.Client, autoScalingGroupName string) error {
+	var instanceIds []string
+	err := poll(ctx, autoScalingGroupPollInterval, autoScalingGroupDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		output, err := client.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{autoScalingGroupName},
+		})
+		if err != nil {
+			return false, err
+		}
+		instanceIds = nil
+		for _, autoScalingGroup := range output.AutoScalingGroups {
+			for _, instance := range autoScalingGroup.Instances {
+				if instance.InstanceId != nil {
+					instanceIds = append(instanceIds, *instance.InstanceId)
+				}
+			}
+		}
+		log.Info().
+			Str("AutoScalingGroupName", autoScalingGroupName).
+			Strs("InstanceIds", instanceIds).
+			Msg("DescribeAutoScalingGroups")
+		return len(instanceIds) == 0, nil
+	})
+	if err == errPollTimeout {
+		return fmt.Errorf("timed out waiting for AutoScalingGroup %s Instances to terminate: %s", autoScalingGroupName, strings.Join(instanceIds, ", "))
+	}
+	return err
+}
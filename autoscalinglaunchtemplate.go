@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// launchTemplateIdTagKey is the key of the tag with which EC2 tags Instances
+// launched from a LaunchTemplate with the LaunchTemplate's ID.
+const launchTemplateIdTagKey = "aws:ec2launchtemplate:id"
+
+// autoScalingGroupLaunchConfigurationNames returns the names of the
+// LaunchConfigurations used by autoScalingGroups.
+func autoScalingGroupLaunchConfigurationNames(autoScalingGroups []types.AutoScalingGroup) stringSet {
+	launchConfigurationNames := newStringSet()
+	for _, autoScalingGroup := range autoScalingGroups {
+		if autoScalingGroup.LaunchConfigurationName != nil {
+			launchConfigurationNames[*autoScalingGroup.LaunchConfigurationName] = struct{}{}
+		}
+	}
+	return launchConfigurationNames
+}
+
+// autoScalingGroupLaunchTemplateIdsAndNames returns the IDs and names of the
+// LaunchTemplates used by autoScalingGroups, either directly or through their
+// MixedInstancesPolicies. A LaunchTemplate may be referred to by either its ID
+// or its name, so both are included in the same set.
+func autoScalingGroupLaunchTemplateIdsAndNames(autoScalingGroups []types.AutoScalingGroup) stringSet {
+	launchTemplateIdsAndNames := newStringSet()
+	add := func(launchTemplateSpecification *types.LaunchTemplateSpecification) {
+		if launchTemplateSpecification == nil {
+			return
+		}
+		if launchTemplateSpecification.LaunchTemplateId != nil {
+			launchTemplateIdsAndNames[*launchTemplateSpecification.LaunchTemplateId] = struct{}{}
+		}
+		if launchTemplateSpecification.LaunchTemplateName != nil {
+			launchTemplateIdsAndNames[*launchTemplateSpecification.LaunchTemplateName] = struct{}{}
+		}
+	}
+	for _, autoScalingGroup := range autoScalingGroups {
+		add(autoScalingGroup.LaunchTemplate)
+		if autoScalingGroup.MixedInstancesPolicy == nil || autoScalingGroup.MixedInstancesPolicy.LaunchTemplate == nil {
+			continue
+		}
+		add(autoScalingGroup.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification)
+		for _, override := range autoScalingGroup.MixedInstancesPolicy.LaunchTemplate.Overrides {
+			add(override.LaunchTemplateSpecification)
+		}
+	}
+	return launchTemplateIdsAndNames
+}
+
+// deleteAutoScalingGroupLaunchTemplates deletes the LaunchTemplates and
+// LaunchConfigurations used by the deleted autoScalingGroups that are not used
+// by any remaining AutoScalingGroup, EC2 Fleet, Spot Fleet request, or
+// Instance. Instances launched by Karpenter from a LaunchTemplate are only
+// visible through their tags. It accumulates errors.
+func deleteAutoScalingGroupLaunchTemplates(ctx context.Context, clients *clients, autoScalingGroups []types.AutoScalingGroup) (errs error) {
+	remainingAutoScalingGroups, err := listAutoScalingGroups(ctx, clients.autoscaling, nil)
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Strs("autoScalingGroupNames", autoScalingGroupNames(remainingAutoScalingGroups)).
+		Msg("listAutoScalingGroups")
+
+	launchTemplateIdsAndNames := autoScalingGroupLaunchTemplateIdsAndNames(autoScalingGroups)
+	if len(launchTemplateIdsAndNames) > 0 {
+		if launchTemplates, err := listLaunchTemplates(ctx, clients.ec2, nil); err != nil {
+			log.Err(err).
+				Msg("listLaunchTemplates")
+			errs = multierr.Append(errs, err)
+		} else if usedLaunchTemplateIdsAndNames, err := listUsedLaunchTemplateIdsAndNames(ctx, clients.ec2, remainingAutoScalingGroups); err != nil {
+			log.Err(err).
+				Msg("listUsedLaunchTemplateIdsAndNames")
+			errs = multierr.Append(errs, err)
+		} else {
+			var unusedLaunchTemplates []ec2types.LaunchTemplate
+			for _, launchTemplate := range launchTemplates {
+				if launchTemplate.LaunchTemplateId == nil || launchTemplate.LaunchTemplateName == nil {
+					continue
+				}
+				if !launchTemplateIdsAndNames.contains(*launchTemplate.LaunchTemplateId) && !launchTemplateIdsAndNames.contains(*launchTemplate.LaunchTemplateName) {
+					continue
+				}
+				if usedLaunchTemplateIdsAndNames.contains(*launchTemplate.LaunchTemplateId) || usedLaunchTemplateIdsAndNames.contains(*launchTemplate.LaunchTemplateName) {
+					continue
+				}
+				unusedLaunchTemplates = append(unusedLaunchTemplates, launchTemplate)
+			}
+			log.Info().
+				Strs("launchTemplateIds", launchTemplateIds(unusedLaunchTemplates)).
+				Msg("listLaunchTemplates")
+			if len(unusedLaunchTemplates) > 0 {
+				err := deleteLaunchTemplates(ctx, clients.ec2, unusedLaunchTemplates)
+				log.Err(err).
+					Strs("launchTemplateIds", launchTemplateIds(unusedLaunchTemplates)).
+					Msg("deleteLaunchTemplates")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	unusedLaunchConfigurationNames := autoScalingGroupLaunchConfigurationNames(autoScalingGroups).
+		subtract(autoScalingGroupLaunchConfigurationNames(remainingAutoScalingGroups))
+	if len(unusedLaunchConfigurationNames) > 0 {
+		launchConfigurationNames := make([]string, 0, len(unusedLaunchConfigurationNames))
+		for launchConfigurationName := range unusedLaunchConfigurationNames {
+			launchConfigurationNames = append(launchConfigurationNames, launchConfigurationName)
+		}
+		sort.Strings(launchConfigurationNames)
+
+		// LaunchConfigurations deleted in earlier tries no longer exist.
+		if launchConfigurationNames, err := listLaunchConfigurationNames(ctx, clients.autoscaling, launchConfigurationNames); err != nil {
+			log.Err(err).
+				Msg("listLaunchConfigurationNames")
+			errs = multierr.Append(errs, err)
+		} else if len(launchConfigurationNames) > 0 {
+			err := deleteLaunchConfigurations(ctx, clients.autoscaling, launchConfigurationNames)
+			log.Err(err).
+				Strs("launchConfigurationNames", launchConfigurationNames).
+				Msg("deleteLaunchConfigurations")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	return
+}
+
+// listUsedLaunchTemplateIdsAndNames returns the IDs and names of the
+// LaunchTemplates used by remainingAutoScalingGroups, by active EC2 Fleets and
+// Spot Fleet requests, and by Instances that are not terminated.
+func listUsedLaunchTemplateIdsAndNames(ctx context.Context, client *ec2.Client, remainingAutoScalingGroups []types.AutoScalingGroup) (stringSet, error) {
+	launchTemplateIdsAndNames := autoScalingGroupLaunchTemplateIdsAndNames(remainingAutoScalingGroups)
+	add := func(launchTemplateSpecification *ec2types.FleetLaunchTemplateSpecification) {
+		if launchTemplateSpecification == nil {
+			return
+		}
+		if launchTemplateSpecification.LaunchTemplateId != nil {
+			launchTemplateIdsAndNames[*launchTemplateSpecification.LaunchTemplateId] = struct{}{}
+		}
+		if launchTemplateSpecification.LaunchTemplateName != nil {
+			launchTemplateIdsAndNames[*launchTemplateSpecification.LaunchTemplateName] = struct{}{}
+		}
+	}
+
+	fleets, err := listFleets(ctx, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, fleet := range fleets {
+		for _, launchTemplateConfig := range fleet.LaunchTemplateConfigs {
+			add(launchTemplateConfig.LaunchTemplateSpecification)
+		}
+	}
+
+	spotFleetRequests, err := listSpotFleetRequests(ctx, client, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, spotFleetRequest := range spotFleetRequests {
+		if spotFleetRequest.SpotFleetRequestConfig == nil {
+			continue
+		}
+		for _, launchTemplateConfig := range spotFleetRequest.SpotFleetRequestConfig.LaunchTemplateConfigs {
+			add(launchTemplateConfig.LaunchTemplateSpecification)
+		}
+	}
+
+	// EC2 tags Instances launched from a LaunchTemplate with its ID.
+	reservations, err := listReservations(ctx, client, []ec2types.Filter{
+		{
+			Name:   aws.String("tag-key"),
+			Values: []string{launchTemplateIdTagKey},
+		},
+		{
+			Name: aws.String("instance-state-name"),
+			Values: []string{
+				string(ec2types.InstanceStateNamePending),
+				string(ec2types.InstanceStateNameRunning),
+				string(ec2types.InstanceStateNameShuttingDown),
+				string(ec2types.InstanceStateNameStopping),
+				string(ec2types.InstanceStateNameStopped),
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			for _, tag := range instance.Tags {
+				if tag.Key != nil && *tag.Key == launchTemplateIdTagKey && tag.Value != nil {
+					launchTemplateIdsAndNames[*tag.Value] = struct{}{}
+				}
+			}
+		}
+	}
+
+	return launchTemplateIdsAndNames, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/batch/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func batchComputeEnvironmentArns(computeEnvironments []types.ComputeEnvironmentDetail) []string {
+	computeEnvironmentArns := make([]string, 0, len(computeEnvironments))
+	for _, computeEnvironment := range computeEnvironments {
+		if computeEnvironment.ComputeEnvironmentArn != nil {
+			computeEnvironmentArns = append(computeEnvironmentArns, *computeEnvironment.ComputeEnvironmentArn)
+		}
+	}
+	return computeEnvironmentArns
+}
+
+func batchJobQueueArns(jobQueues []types.JobQueueDetail) []string {
+	jobQueueArns := make([]string, 0, len(jobQueues))
+	for _, jobQueue := range jobQueues {
+		if jobQueue.JobQueueArn != nil {
+			jobQueueArns = append(jobQueueArns, *jobQueue.JobQueueArn)
+		}
+	}
+	return jobQueueArns
+}
+
+// deleteBatchComputeEnvironments disables computeEnvironments, waits for them
+// to be disabled, and then deletes them and waits for them to be deleted.
+// Their job queues must already have been deleted.
+func deleteBatchComputeEnvironments(ctx context.Context, client *batch.Client, computeEnvironments []types.ComputeEnvironmentDetail) error {
+	var errs error
+	computeEnvironmentArns := newStringSet()
+	for _, computeEnvironment := range computeEnvironments {
+		if computeEnvironment.ComputeEnvironmentArn == nil {
+			continue
+		}
+		computeEnvironmentArns[*computeEnvironment.ComputeEnvironmentArn] = struct{}{}
+		if computeEnvironment.State != types.CEStateEnabled {
+			continue
+		}
+		_, err := client.UpdateComputeEnvironment(ctx, &batch.UpdateComputeEnvironmentInput{
+			ComputeEnvironment: computeEnvironment.ComputeEnvironmentArn,
+			State:              types.CEStateDisabled,
+		})
+		log.Err(err).
+			Str("ComputeEnvironment", *computeEnvironment.ComputeEnvironmentArn).
+			Msg("UpdateComputeEnvironment")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	// Wait for the compute environments to be disabled.
+	err := waitForBatchComputeEnvironments(ctx, client, computeEnvironmentArns, "disabled", func(computeEnvironment types.ComputeEnvironmentDetail) bool {
+		return computeEnvironment.State == types.CEStateDisabled && computeEnvironment.Status != types.CEStatusUpdating
+	})
+	if err != nil {
+		return err
+	}
+
+	for computeEnvironmentArn := range computeEnvironmentArns {
+		_, err := client.DeleteComputeEnvironment(ctx, &batch.DeleteComputeEnvironmentInput{
+			ComputeEnvironment: aws.String(computeEnvironmentArn),
+		})
+		log.Err(err).
+			Str("ComputeEnvironment", computeEnvironmentArn).
+			Msg("DeleteComputeEnvironment")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	// Wait for the compute environments to be deleted.
+	return waitForBatchComputeEnvironments(ctx, client, computeEnvironmentArns, "deleted", func(computeEnvironment types.ComputeEnvironmentDetail) bool {
+		return computeEnvironment.Status == types.CEStatusDeleted
+	})
+}
+
+// deleteBatchComputeEnvironmentsInVpc deletes the Batch compute environments
+// with subnets in the VPC vpcId, together with the job queues that use them,
+// so that they stop launching Instances in the VPC.
+func deleteBatchComputeEnvironmentsInVpc(ctx context.Context, client *batch.Client, ec2Client *ec2.Client, vpcId string) error {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+
+	computeEnvironments, err := listBatchComputeEnvironments(ctx, client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listBatchComputeEnvironments")
+		return err
+	}
+	log.Info().
+		Strs("computeEnvironmentArns", batchComputeEnvironmentArns(computeEnvironments)).
+		Msg("listBatchComputeEnvironments")
+	if len(computeEnvironments) == 0 {
+		return nil
+	}
+
+	jobQueues, err := listBatchJobQueues(ctx, client, newStringSet(batchComputeEnvironmentArns(computeEnvironments)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listBatchJobQueues")
+		return err
+	}
+	log.Info().
+		Strs("jobQueueArns", batchJobQueueArns(jobQueues)).
+		Msg("listBatchJobQueues")
+	if len(jobQueues) > 0 {
+		err := deleteBatchJobQueues(ctx, client, jobQueues)
+		log.Err(err).
+			Strs("jobQueueArns", batchJobQueueArns(jobQueues)).
+			Msg("deleteBatchJobQueues")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteBatchComputeEnvironments(ctx, client, computeEnvironments)
+	log.Err(err).
+		Strs("computeEnvironmentArns", batchComputeEnvironmentArns(computeEnvironments)).
+		Msg("deleteBatchComputeEnvironments")
+	return err
+}
+
+// deleteBatchJobQueues disables jobQueues, waits for them to be disabled, and
+// then deletes them and waits for them to be deleted.
+func deleteBatchJobQueues(ctx context.Context, client *batch.Client, jobQueues []types.JobQueueDetail) error {
+	var errs error
+	jobQueueArns := newStringSet()
+	for _, jobQueue := range jobQueues {
+		if jobQueue.JobQueueArn == nil {
+			continue
+		}
+		jobQueueArns[*jobQueue.JobQueueArn] = struct{}{}
+		if jobQueue.State != types.JQStateEnabled {
+			continue
+		}
+		_, err := client.UpdateJobQueue(ctx, &batch.UpdateJobQueueInput{
+			JobQueue: jobQueue.JobQueueArn,
+			State:    types.JQStateDisabled,
+		})
+		log.Err(err).
+			Str("JobQueue", *jobQueue.JobQueueArn).
+			Msg("UpdateJobQueue")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	// Wait for the job queues to be disabled.
+	err := waitForBatchJobQueues(ctx, client, jobQueueArns, "disabled", func(jobQueue types.JobQueueDetail) bool {
+		return jobQueue.State == types.JQStateDisabled && jobQueue.Status != types.JQStatusUpdating
+	})
+	if err != nil {
+		return err
+	}
+
+	for jobQueueArn := range jobQueueArns {
+		_, err := client.DeleteJobQueue(ctx, &batch.DeleteJobQueueInput{
+			JobQueue: aws.String(jobQueueArn),
+		})
+		log.Err(err).
+			Str("JobQueue", jobQueueArn).
+			Msg("DeleteJobQueue")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	// Wait for the job queues to be deleted.
+	return waitForBatchJobQueues(ctx, client, jobQueueArns, "deleted", func(jobQueue types.JobQueueDetail) bool {
+		return jobQueue.Status == types.JQStatusDeleted
+	})
+}
+
+// listBatchComputeEnvironments returns the Batch compute environments that are
+// not being deleted with a subnet in subnetIds, or all compute environments,
+// including those being deleted, if subnetIds is nil.
+func listBatchComputeEnvironments(ctx context.Context, client *batch.Client, subnetIds stringSet) ([]types.ComputeEnvironmentDetail, error) {
+	input := batch.DescribeComputeEnvironmentsInput{}
+	var computeEnvironments []types.ComputeEnvironmentDetail
+	for {
+		output, err := client.DescribeComputeEnvironments(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	COMPUTE_ENVIRONMENT:
+		for _, computeEnvironment := range output.ComputeEnvironments {
+			if subnetIds == nil {
+				computeEnvironments = append(computeEnvironments, computeEnvironment)
+				continue
+			}
+			if computeEnvironment.Status == types.CEStatusDeleting || computeEnvironment.Status == types.CEStatusDeleted {
+				continue
+			}
+			if computeEnvironment.ComputeResources == nil {
+				continue
+			}
+			for _, subnetId := range computeEnvironment.ComputeResources.Subnets {
+				if subnetIds.contains(subnetId) {
+					computeEnvironments = append(computeEnvironments, computeEnvironment)
+					continue COMPUTE_ENVIRONMENT
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return computeEnvironments, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listBatchJobQueues returns the Batch job queues that are not being deleted
+// that use one of computeEnvironmentArns, or all job queues, including those
+// being deleted, if computeEnvironmentArns is nil.
+func listBatchJobQueues(ctx context.Context, client *batch.Client, computeEnvironmentArns stringSet) ([]types.JobQueueDetail, error) {
+	input := batch.DescribeJobQueuesInput{}
+	var jobQueues []types.JobQueueDetail
+	for {
+		output, err := client.DescribeJobQueues(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	JOB_QUEUE:
+		for _, jobQueue := range output.JobQueues {
+			if computeEnvironmentArns == nil {
+				jobQueues = append(jobQueues, jobQueue)
+				continue
+			}
+			if jobQueue.Status == types.JQStatusDeleting || jobQueue.Status == types.JQStatusDeleted {
+				continue
+			}
+			for _, computeEnvironmentOrder := range jobQueue.ComputeEnvironmentOrder {
+				if computeEnvironmentOrder.ComputeEnvironment != nil && computeEnvironmentArns.contains(*computeEnvironmentOrder.ComputeEnvironment) {
+					jobQueues = append(jobQueues, jobQueue)
+					continue JOB_QUEUE
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return jobQueues, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// waitForBatchComputeEnvironments waits until done returns true for all of
+// the compute environments computeEnvironmentArns that still exist.
+func waitForBatchComputeEnvironments(ctx context.Context, client *batch.Client, computeEnvironmentArns stringSet, description string, done func(types.ComputeEnvironmentDetail) bool) error {
+	var remaining []string
+	err := poll(ctx, batchPollInterval, batchWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		computeEnvironments, err := listBatchComputeEnvironments(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, computeEnvironment := range computeEnvironments {
+			if computeEnvironment.ComputeEnvironmentArn != nil && computeEnvironmentArns.contains(*computeEnvironment.ComputeEnvironmentArn) && !done(computeEnvironment) {
+				remaining = append(remaining, *computeEnvironment.ComputeEnvironmentArn)
+			}
+		}
+		log.Info().
+			Strs("ComputeEnvironmentArns", remaining).
+			Msg("DescribeComputeEnvironments")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for Batch compute environments to be %s: %s", description, strings.Join(remaining, ", "))
+	}
+	return err
+}
+
+// waitForBatchJobQueues waits until done returns true for all of the job
+// queues jobQueueArns that still exist.
+func waitForBatchJobQueues(ctx context.Context, client *batch.Client, jobQueueArns stringSet, description string, done func(types.JobQueueDetail) bool) error {
+	var remaining []string
+	err := poll(ctx, batchPollInterval, batchWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		jobQueues, err := listBatchJobQueues(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, jobQueue := range jobQueues {
+			if jobQueue.JobQueueArn != nil && jobQueueArns.contains(*jobQueue.JobQueueArn) && !done(jobQueue) {
+				remaining = append(remaining, *jobQueue.JobQueueArn)
+			}
+		}
+		log.Info().
+			Strs("JobQueueArns", remaining).
+			Msg("DescribeJobQueues")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for Batch job queues to be %s: %s", description, strings.Join(remaining, ", "))
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"go.uber.org/multierr"
+)
+
+// deleteCiliumNetworkInterfaces unassigns the addresses and delegated prefixes
+// of the NetworkInterfaces created by Cilium's ENI IPAM mode, and then deletes
+// them.
+func deleteCiliumNetworkInterfaces(ctx context.Context, client *ec2.Client, networkInterfaces []ec2types.NetworkInterface) (errs error) {
+	for _, networkInterface := range networkInterfaces {
+		if networkInterface.NetworkInterfaceId == nil {
+			continue
+		}
+		errs = multierr.Append(errs, unassignNetworkInterfaceAddresses(ctx, client, networkInterface))
+	}
+	errs = multierr.Append(errs, deleteNetworkInterfaces(ctx, client, networkInterfaces))
+	return
+}
+
+// listCiliumNetworkInterfaces returns all NetworkInterfaces in the region
+// created by the Cilium operator for the cluster clusterName, including those
+// outside the cluster's VPC.
+func listCiliumNetworkInterfaces(ctx context.Context, client *ec2.Client, clusterName string) ([]ec2types.NetworkInterface, error) {
+	return listNetworkInterfaces(ctx, client, []ec2types.Filter{
+		{
+			Name:   aws.String("tag:io.cilium/cilium-managed"),
+			Values: []string{"true"},
+		},
+		{
+			Name:   aws.String("tag:io.cilium/cluster-name"),
+			Values: []string{clusterName},
+		},
+	})
+}
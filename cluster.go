@@ -2,29 +2,85 @@ package main
 
 import (
 	"context"
+	"errors"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go-v2/service/eks/types"
 	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
 )
 
+func clusterNames(clusters []types.Cluster) []string {
+	clusterNames := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.Name != nil {
+			clusterNames = append(clusterNames, *cluster.Name)
+		}
+	}
+	return clusterNames
+}
+
+// deleteCluster deletes cluster's NodeGroups and FargateProfiles, then deletes
+// cluster itself and waits for it to be deleted. A cluster that has already
+// been deleted is not an error.
 func deleteCluster(ctx context.Context, client *eks.Client, cluster *types.Cluster) error {
-	if err := deleteClusterNodeGroups(ctx, client, cluster); err != nil {
-		log.Err(err).
-			Str("Name", *cluster.Name).
-			Msg("DeleteClusterNodeGroups")
+	var resourceNotFoundExceptionErr *types.ResourceNotFoundException
+	err := deleteClusterNodeGroups(ctx, client, cluster)
+	log.Err(err).
+		Str("Name", *cluster.Name).
+		Msg("DeleteClusterNodeGroups")
+	switch {
+	case errors.As(err, &resourceNotFoundExceptionErr):
+		return nil
+	case err != nil:
 		return err
 	}
-	_, err := client.DeleteCluster(ctx, &eks.DeleteClusterInput{
+	err = deleteClusterFargateProfiles(ctx, client, cluster)
+	log.Err(err).
+		Str("Name", *cluster.Name).
+		Msg("DeleteClusterFargateProfiles")
+	switch {
+	case errors.As(err, &resourceNotFoundExceptionErr):
+		return nil
+	case err != nil:
+		return err
+	}
+	_, err = client.DeleteCluster(ctx, &eks.DeleteClusterInput{
 		Name: cluster.Name,
 	})
 	log.Err(err).
 		Str("Name", *cluster.Name).
 		Msg("DeleteCluster")
+	switch {
+	case errors.As(err, &resourceNotFoundExceptionErr):
+		return nil
+	case err != nil:
+		return err
+	}
+
+	clusterDeletedWaiter := eks.NewClusterDeletedWaiter(client)
+	log.Info().
+		Str("Name", *cluster.Name).
+		Msg("ClusterDeletedWaiter.Wait")
+	err = clusterDeletedWaiter.Wait(ctx, &eks.DescribeClusterInput{
+		Name: cluster.Name,
+	}, clusterDeletedWaiterMaxDuration)
+	log.Err(err).
+		Msg("ClusterDeletedWaiter.Wait")
 	return err
 }
 
+func deleteClusters(ctx context.Context, client *eks.Client, clusters []types.Cluster) (errs error) {
+	for i := range clusters {
+		if clusters[i].Name == nil {
+			continue
+		}
+		errs = multierr.Append(errs, deleteCluster(ctx, client, &clusters[i]))
+	}
+	return
+}
+
 func listCluster(ctx context.Context, client *eks.Client, clusterName string) (*types.Cluster, error) {
 	output, err := client.DescribeCluster(ctx, &eks.DescribeClusterInput{
 		Name: aws.String(clusterName),
@@ -34,3 +90,35 @@ func listCluster(ctx context.Context, client *eks.Client, clusterName string) (*
 	}
 	return output.Cluster, nil
 }
+
+// listClusters returns all clusters in the region whose resources are in the
+// VPC with ID vpcId.
+func listClusters(ctx context.Context, client *eks.Client, vpcId string) ([]types.Cluster, error) {
+	input := eks.ListClustersInput{}
+	var clusters []types.Cluster
+	for {
+		output, err := client.ListClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, clusterName := range output.Clusters {
+			cluster, err := listCluster(ctx, client, clusterName)
+			// Ignore clusters that were deleted since they were listed.
+			var resourceNotFoundExceptionErr *types.ResourceNotFoundException
+			switch {
+			case errors.As(err, &resourceNotFoundExceptionErr):
+				continue
+			case err != nil:
+				return nil, err
+			}
+			if cluster.ResourcesVpcConfig == nil || cluster.ResourcesVpcConfig.VpcId == nil || *cluster.ResourcesVpcConfig.VpcId != vpcId {
+				continue
+			}
+			clusters = append(clusters, *cluster)
+		}
+		if output.NextToken == nil {
+			return clusters, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
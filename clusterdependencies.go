@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteClusterDependencies deletes the cluster clusterName and the resources
+// that it created in the VPC with ID vpcId, identified by the tag
+// kubernetes.io/cluster/$CLUSTER_NAME. Unlike deleteVpcDependencies, it never
+// deletes any of the VPC's own infrastructure (Subnets, RouteTables, gateways,
+// etc.), so it is safe to use on clusters in shared VPCs. cluster may be nil if
+// the cluster has already been deleted. It accumulates errors.
+func deleteClusterDependencies(ctx context.Context, clients *clients, clusterName string, cluster *ekstypes.Cluster, vpcId string, resources stringSet, autoScalingFilters []autoscalingtypes.Filter, opts *options) (errs error) {
+	filters := append(ec2VpcFilter(vpcId), ec2ClusterTagFilter(clusterName)...)
+
+	if resources.contains("Clusters") && cluster != nil {
+		err := deleteCluster(ctx, clients.eks, cluster)
+		log.Err(err).
+			Str("clusterName", clusterName).
+			Msg("deleteCluster")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("Karpenter") {
+		err := deleteKarpenterResources(ctx, clients, clusterName, vpcId, opts)
+		log.Err(err).
+			Str("clusterName", clusterName).
+			Msg("deleteKarpenterResources")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("LoadBalancers") {
+		err := deleteKubernetesLoadBalancers(ctx, clients, clusterName)
+		log.Err(err).
+			Str("clusterName", clusterName).
+			Msg("deleteKubernetesLoadBalancers")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("AutoScalingGroups") && len(autoScalingFilters) > 0 {
+		if autoScalingGroups, err := listAutoScalingGroups(ctx, clients.autoscaling, autoScalingFilters); err != nil {
+			log.Err(err).
+				Msg("listAutoScalingGroups")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("autoScalingGroupNames", autoScalingGroupNames(autoScalingGroups)).
+				Msg("listAutoScalingGroups")
+			if len(autoScalingGroups) > 0 {
+				err := deleteAutoScalingGroups(ctx, clients.autoscaling, autoScalingGroups, opts.forceDeleteAutoScalingGroups)
+				log.Err(err).
+					Strs("autoScalingGroupNames", autoScalingGroupNames(autoScalingGroups)).
+					Msg("deleteAutoScalingGroups")
+				errs = multierr.Append(errs, err)
+				opts.deletedAutoScalingGroups = append(opts.deletedAutoScalingGroups, autoScalingGroups...)
+			}
+		}
+	}
+
+	if resources.contains("AutoScalingGroups") && opts.deleteLaunchTemplates && len(opts.deletedAutoScalingGroups) > 0 {
+		err := deleteAutoScalingGroupLaunchTemplates(ctx, clients, opts.deletedAutoScalingGroups)
+		log.Err(err).
+			Strs("autoScalingGroupNames", autoScalingGroupNames(opts.deletedAutoScalingGroups)).
+			Msg("deleteAutoScalingGroupLaunchTemplates")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("Reservations") {
+		if reservations, err := listReservations(ctx, clients.ec2, filters); err != nil {
+			log.Err(err).Msg("listReservations")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("instanceIds", instanceIds(reservations)).
+				Msg("listReservations")
+			if len(reservations) > 0 {
+				err := terminateInstancesInReservations(ctx, clients.ec2, reservations, opts.force)
+				log.Err(err).
+					Strs("instanceIds", instanceIds(reservations)).
+					Msg("terminateInstancesInReservations")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	if resources.contains("Volumes") && clusterName != "" {
+		if subnets, err := listSubnets(ctx, clients.ec2, vpcId); err != nil {
+			log.Err(err).
+				Msg("listSubnets")
+			errs = multierr.Append(errs, err)
+		} else if volumes, err := listClusterVolumes(ctx, clients.ec2, clusterName, subnetAvailabilityZones(subnets)); err != nil {
+			log.Err(err).
+				Msg("listClusterVolumes")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("volumeIds", volumeIds(volumes)).
+				Msg("listClusterVolumes")
+			if len(volumes) > 0 {
+				err := deleteVolumes(ctx, clients.ec2, volumes, opts.snapshotVolumes)
+				log.Err(err).
+					Strs("volumeIds", volumeIds(volumes)).
+					Msg("deleteVolumes")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	if resources.contains("NetworkInterfaces") {
+		if networkInterfaces, err := listCiliumNetworkInterfaces(ctx, clients.ec2, clusterName); err != nil {
+			log.Err(err).
+				Msg("listCiliumNetworkInterfaces")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+				Msg("listCiliumNetworkInterfaces")
+			if len(networkInterfaces) > 0 {
+				err := deleteCiliumNetworkInterfaces(ctx, clients.ec2, networkInterfaces)
+				log.Err(err).
+					Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+					Msg("deleteCiliumNetworkInterfaces")
+				errs = multierr.Append(errs, err)
+			}
+		}
+
+		if networkInterfaces, err := listNetworkInterfaces(ctx, clients.ec2, filters); err != nil {
+			log.Err(err).
+				Msg("listNetworkInterfaces")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+				Msg("listNetworkInterfaces")
+			if len(networkInterfaces) > 0 {
+				err := deleteNetworkInterfaces(ctx, clients.ec2, networkInterfaces)
+				log.Err(err).
+					Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+					Msg("deleteNetworkInterfaces")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	if resources.contains("SecurityGroups") {
+		err := deleteKubernetesLoadBalancerSecurityGroups(ctx, clients, clusterName)
+		log.Err(err).
+			Str("clusterName", clusterName).
+			Msg("deleteKubernetesLoadBalancerSecurityGroups")
+		errs = multierr.Append(errs, err)
+
+		if securityGroups, err := listClusterSecurityGroups(ctx, clients, cluster, filters); err != nil {
+			log.Err(err).
+				Msg("listClusterSecurityGroups")
+			errs = multierr.Append(errs, err)
+		} else {
+			securityGroups, sharedSecurityGroups := partitionSharedSecurityGroups(securityGroups, clusterName)
+			if len(sharedSecurityGroups) > 0 {
+				log.Info().
+					Strs("securityGroupIds", securityGroupIds(sharedSecurityGroups)).
+					Msg("skipping shared SecurityGroups")
+			}
+			log.Info().
+				Strs("securityGroupIds", securityGroupIds(securityGroups)).
+				Msg("listClusterSecurityGroups")
+			if len(securityGroups) > 0 {
+				err := deleteSecurityGroups(ctx, clients.ec2, vpcId, securityGroups)
+				log.Err(err).
+					Strs("securityGroupIds", securityGroupIds(securityGroups)).
+					Msg("deleteSecurityGroups")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	return
+}
+
+// listClusterSecurityGroups returns the SecurityGroups matching filters and,
+// if cluster is not nil, the cluster's own SecurityGroup.
+func listClusterSecurityGroups(ctx context.Context, clients *clients, cluster *ekstypes.Cluster, filters []ec2types.Filter) ([]ec2types.SecurityGroup, error) {
+	securityGroups, err := listNonDefaultSecurityGroups(ctx, clients.ec2, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	if cluster == nil || cluster.ResourcesVpcConfig == nil || cluster.ResourcesVpcConfig.ClusterSecurityGroupId == nil {
+		return securityGroups, nil
+	}
+	clusterSecurityGroupId := *cluster.ResourcesVpcConfig.ClusterSecurityGroupId
+	if newStringSet(securityGroupIds(securityGroups)...).contains(clusterSecurityGroupId) {
+		return securityGroups, nil
+	}
+	clusterSecurityGroups, err := listNonDefaultSecurityGroups(ctx, clients.ec2, []ec2types.Filter{
+		{
+			Name:   aws.String("group-id"),
+			Values: []string{clusterSecurityGroupId},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(securityGroups, clusterSecurityGroups...), nil
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// dbClusterFinalSnapshotIdentifier returns the identifier of the final snapshot
+// of dbCluster, or nil if finalSnapshotPrefix is empty and no final snapshot
+// should be taken.
+func dbClusterFinalSnapshotIdentifier(dbCluster types.DBCluster, finalSnapshotPrefix string) *string {
+	if finalSnapshotPrefix == "" || dbCluster.DBClusterIdentifier == nil {
+		return nil
+	}
+	return aws.String(finalSnapshotPrefix + *dbCluster.DBClusterIdentifier)
+}
+
+func dbClusterIdentifiers(dbClusters []types.DBCluster) []string {
+	dbClusterIdentifiers := make([]string, 0, len(dbClusters))
+	for _, dbCluster := range dbClusters {
+		if dbCluster.DBClusterIdentifier != nil {
+			dbClusterIdentifiers = append(dbClusterIdentifiers, *dbCluster.DBClusterIdentifier)
+		}
+	}
+	return dbClusterIdentifiers
+}
+
+// dbInstanceFinalSnapshotIdentifier returns the identifier of the final
+// snapshot of dbInstance, or nil if no final snapshot should be taken, either
+// because finalSnapshotPrefix is empty or because dbInstance is a member of a
+// DB cluster, whose final snapshot is taken instead.
+func dbInstanceFinalSnapshotIdentifier(dbInstance types.DBInstance, finalSnapshotPrefix string) *string {
+	if finalSnapshotPrefix == "" || dbInstance.DBInstanceIdentifier == nil || dbInstance.DBClusterIdentifier != nil {
+		return nil
+	}
+	return aws.String(finalSnapshotPrefix + *dbInstance.DBInstanceIdentifier)
+}
+
+func dbInstanceIdentifiers(dbInstances []types.DBInstance) []string {
+	dbInstanceIdentifiers := make([]string, 0, len(dbInstances))
+	for _, dbInstance := range dbInstances {
+		if dbInstance.DBInstanceIdentifier != nil {
+			dbInstanceIdentifiers = append(dbInstanceIdentifiers, *dbInstance.DBInstanceIdentifier)
+		}
+	}
+	return dbInstanceIdentifiers
+}
+
+func dbSubnetGroupNames(dbSubnetGroups []types.DBSubnetGroup) []string {
+	dbSubnetGroupNames := make([]string, 0, len(dbSubnetGroups))
+	for _, dbSubnetGroup := range dbSubnetGroups {
+		if dbSubnetGroup.DBSubnetGroupName != nil {
+			dbSubnetGroupNames = append(dbSubnetGroupNames, *dbSubnetGroup.DBSubnetGroupName)
+		}
+	}
+	return dbSubnetGroupNames
+}
+
+// deleteDatabasesInVpc deletes the DB instances and DB clusters (RDS, Aurora,
+// DocumentDB, and Neptune) in the VPC vpcId, waits for them to be deleted, and
+// then deletes the VPC's DB subnet groups. If opts.force is true then deletion
+// protection is disabled first. If opts.finalSnapshotPrefix is set then a
+// final snapshot of each DB instance and DB cluster is taken, named with the
+// prefix followed by its identifier. It accumulates errors.
+func deleteDatabasesInVpc(ctx context.Context, client *rds.Client, vpcId string, opts *options) (errs error) {
+	dbSubnetGroups, err := listDBSubnetGroups(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listDBSubnetGroups")
+		return err
+	}
+	log.Info().
+		Strs("dbSubnetGroupNames", dbSubnetGroupNames(dbSubnetGroups)).
+		Msg("listDBSubnetGroups")
+
+	if dbInstances, err := listDBInstances(ctx, client, vpcId); err != nil {
+		log.Err(err).
+			Msg("listDBInstances")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("dbInstanceIdentifiers", dbInstanceIdentifiers(dbInstances)).
+			Msg("listDBInstances")
+		if len(dbInstances) > 0 {
+			err := deleteDBInstances(ctx, client, dbInstances, opts)
+			log.Err(err).
+				Strs("dbInstanceIdentifiers", dbInstanceIdentifiers(dbInstances)).
+				Msg("deleteDBInstances")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if dbClusters, err := listDBClusters(ctx, client, newStringSet(dbSubnetGroupNames(dbSubnetGroups)...)); err != nil {
+		log.Err(err).
+			Msg("listDBClusters")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("dbClusterIdentifiers", dbClusterIdentifiers(dbClusters)).
+			Msg("listDBClusters")
+		if len(dbClusters) > 0 {
+			err := deleteDBClusters(ctx, client, dbClusters, opts)
+			log.Err(err).
+				Strs("dbClusterIdentifiers", dbClusterIdentifiers(dbClusters)).
+				Msg("deleteDBClusters")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return
+	}
+
+	if len(dbSubnetGroups) > 0 {
+		err := deleteDBSubnetGroups(ctx, client, dbSubnetGroups)
+		log.Err(err).
+			Strs("dbSubnetGroupNames", dbSubnetGroupNames(dbSubnetGroups)).
+			Msg("deleteDBSubnetGroups")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// deleteDBClusters deletes dbClusters and waits for them to be deleted. Their
+// DB instances must already have been deleted.
+func deleteDBClusters(ctx context.Context, client *rds.Client, dbClusters []types.DBCluster, opts *options) error {
+	var errs error
+	var deletingDBClusterIdentifiers []string
+	for _, dbCluster := range dbClusters {
+		if dbCluster.DBClusterIdentifier == nil {
+			continue
+		}
+		if aws.ToString(dbCluster.Status) == "deleting" {
+			deletingDBClusterIdentifiers = append(deletingDBClusterIdentifiers, *dbCluster.DBClusterIdentifier)
+			continue
+		}
+
+		// Disable deletion protection.
+		if aws.ToBool(dbCluster.DeletionProtection) {
+			if !opts.force {
+				errs = multierr.Append(errs, fmt.Errorf("%s: deletion protection enabled", *dbCluster.DBClusterIdentifier))
+				continue
+			}
+			_, err := client.ModifyDBCluster(ctx, &rds.ModifyDBClusterInput{
+				DBClusterIdentifier: dbCluster.DBClusterIdentifier,
+				ApplyImmediately:    true,
+				DeletionProtection:  aws.Bool(false),
+			})
+			log.Err(err).
+				Str("DBClusterIdentifier", *dbCluster.DBClusterIdentifier).
+				Msg("ModifyDBCluster")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+
+			// The DB cluster cannot be deleted while it is being modified.
+			err = waitForDBClusterAvailable(ctx, client, *dbCluster.DBClusterIdentifier)
+			log.Err(err).
+				Str("DBClusterIdentifier", *dbCluster.DBClusterIdentifier).
+				Msg("waitForDBClusterAvailable")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+		}
+
+		finalDBSnapshotIdentifier := dbClusterFinalSnapshotIdentifier(dbCluster, opts.finalSnapshotPrefix)
+		input := rds.DeleteDBClusterInput{
+			DBClusterIdentifier:       dbCluster.DBClusterIdentifier,
+			FinalDBSnapshotIdentifier: finalDBSnapshotIdentifier,
+			SkipFinalSnapshot:         finalDBSnapshotIdentifier == nil,
+		}
+		_, err := client.DeleteDBCluster(ctx, &input)
+		log.Err(err).
+			Str("DBClusterIdentifier", *dbCluster.DBClusterIdentifier).
+			Str("FinalDBSnapshotIdentifier", aws.ToString(input.FinalDBSnapshotIdentifier)).
+			Msg("DeleteDBCluster")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingDBClusterIdentifiers = append(deletingDBClusterIdentifiers, *dbCluster.DBClusterIdentifier)
+		}
+	}
+	if len(deletingDBClusterIdentifiers) == 0 {
+		return errs
+	}
+
+	// Wait for the DB clusters to be deleted.
+	var remaining []string
+	err := poll(ctx, databasePollInterval, databaseDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		output, err := client.DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("db-cluster-id"),
+					Values: deletingDBClusterIdentifiers,
+				},
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+		remaining = dbClusterIdentifiers(output.DBClusters)
+		log.Info().
+			Strs("DBClusterIdentifiers", remaining).
+			Msg("DescribeDBClusters")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for DB clusters to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteDBInstances deletes dbInstances and waits for them to be deleted.
+// Final snapshots are not taken of DB instances in DB clusters, as their data
+// belongs to the DB cluster.
+func deleteDBInstances(ctx context.Context, client *rds.Client, dbInstances []types.DBInstance, opts *options) error {
+	var errs error
+	var deletingDBInstanceIdentifiers []string
+	for _, dbInstance := range dbInstances {
+		if dbInstance.DBInstanceIdentifier == nil {
+			continue
+		}
+		if aws.ToString(dbInstance.DBInstanceStatus) == "deleting" {
+			deletingDBInstanceIdentifiers = append(deletingDBInstanceIdentifiers, *dbInstance.DBInstanceIdentifier)
+			continue
+		}
+
+		// Disable deletion protection.
+		if dbInstance.DeletionProtection {
+			if !opts.force {
+				errs = multierr.Append(errs, fmt.Errorf("%s: deletion protection enabled", *dbInstance.DBInstanceIdentifier))
+				continue
+			}
+			_, err := client.ModifyDBInstance(ctx, &rds.ModifyDBInstanceInput{
+				DBInstanceIdentifier: dbInstance.DBInstanceIdentifier,
+				ApplyImmediately:     true,
+				DeletionProtection:   aws.Bool(false),
+			})
+			log.Err(err).
+				Str("DBInstanceIdentifier", *dbInstance.DBInstanceIdentifier).
+				Msg("ModifyDBInstance")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+
+			// The DB instance cannot be deleted while it is being modified.
+			dbInstanceAvailableWaiter := rds.NewDBInstanceAvailableWaiter(client)
+			log.Info().
+				Str("DBInstanceIdentifier", *dbInstance.DBInstanceIdentifier).
+				Msg("DBInstanceAvailableWaiter.Wait")
+			err = dbInstanceAvailableWaiter.Wait(ctx, &rds.DescribeDBInstancesInput{
+				DBInstanceIdentifier: dbInstance.DBInstanceIdentifier,
+			}, databaseAvailableWaiterMaxDuration)
+			log.Err(err).
+				Msg("DBInstanceAvailableWaiter.Wait")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+		}
+
+		finalDBSnapshotIdentifier := dbInstanceFinalSnapshotIdentifier(dbInstance, opts.finalSnapshotPrefix)
+		input := rds.DeleteDBInstanceInput{
+			DBInstanceIdentifier:      dbInstance.DBInstanceIdentifier,
+			FinalDBSnapshotIdentifier: finalDBSnapshotIdentifier,
+			SkipFinalSnapshot:         finalDBSnapshotIdentifier == nil,
+		}
+		_, err := client.DeleteDBInstance(ctx, &input)
+		log.Err(err).
+			Str("DBInstanceIdentifier", *dbInstance.DBInstanceIdentifier).
+			Str("FinalDBSnapshotIdentifier", aws.ToString(input.FinalDBSnapshotIdentifier)).
+			Msg("DeleteDBInstance")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingDBInstanceIdentifiers = append(deletingDBInstanceIdentifiers, *dbInstance.DBInstanceIdentifier)
+		}
+	}
+	if len(deletingDBInstanceIdentifiers) == 0 {
+		return errs
+	}
+
+	// Wait for the DB instances to be deleted.
+	dbInstanceDeletedWaiter := rds.NewDBInstanceDeletedWaiter(client)
+	log.Info().
+		Strs("DBInstanceIdentifiers", deletingDBInstanceIdentifiers).
+		Msg("DBInstanceDeletedWaiter.Wait")
+	err := dbInstanceDeletedWaiter.Wait(ctx, &rds.DescribeDBInstancesInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("db-instance-id"),
+				Values: deletingDBInstanceIdentifiers,
+			},
+		},
+	}, databaseDeletedWaiterMaxDuration)
+	log.Err(err).
+		Msg("DBInstanceDeletedWaiter.Wait")
+	return multierr.Append(errs, err)
+}
+
+// deleteDBSubnetGroups deletes dbSubnetGroups, except for the default DB
+// subnet group, which cannot be deleted.
+func deleteDBSubnetGroups(ctx context.Context, client *rds.Client, dbSubnetGroups []types.DBSubnetGroup) (errs error) {
+	for _, dbSubnetGroup := range dbSubnetGroups {
+		if dbSubnetGroup.DBSubnetGroupName == nil || *dbSubnetGroup.DBSubnetGroupName == "default" {
+			continue
+		}
+		_, err := client.DeleteDBSubnetGroup(ctx, &rds.DeleteDBSubnetGroupInput{
+			DBSubnetGroupName: dbSubnetGroup.DBSubnetGroupName,
+		})
+		log.Err(err).
+			Str("DBSubnetGroupName", *dbSubnetGroup.DBSubnetGroupName).
+			Msg("DeleteDBSubnetGroup")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// listDBClusters returns the DB clusters whose DB subnet group is one of
+// dbSubnetGroupNames.
+func listDBClusters(ctx context.Context, client *rds.Client, dbSubnetGroupNames stringSet) ([]types.DBCluster, error) {
+	input := rds.DescribeDBClustersInput{}
+	var dbClusters []types.DBCluster
+	for {
+		output, err := client.DescribeDBClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, dbCluster := range output.DBClusters {
+			if dbCluster.DBSubnetGroup != nil && dbSubnetGroupNames.contains(*dbCluster.DBSubnetGroup) {
+				dbClusters = append(dbClusters, dbCluster)
+			}
+		}
+		if output.Marker == nil {
+			return dbClusters, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// listDBInstances returns the DB instances in the VPC vpcId.
+func listDBInstances(ctx context.Context, client *rds.Client, vpcId string) ([]types.DBInstance, error) {
+	input := rds.DescribeDBInstancesInput{}
+	var dbInstances []types.DBInstance
+	for {
+		output, err := client.DescribeDBInstances(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, dbInstance := range output.DBInstances {
+			if dbInstance.DBSubnetGroup != nil && aws.ToString(dbInstance.DBSubnetGroup.VpcId) == vpcId {
+				dbInstances = append(dbInstances, dbInstance)
+			}
+		}
+		if output.Marker == nil {
+			return dbInstances, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// listDBSubnetGroups returns the DB subnet groups in the VPC vpcId.
+func listDBSubnetGroups(ctx context.Context, client *rds.Client, vpcId string) ([]types.DBSubnetGroup, error) {
+	input := rds.DescribeDBSubnetGroupsInput{}
+	var dbSubnetGroups []types.DBSubnetGroup
+	for {
+		output, err := client.DescribeDBSubnetGroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, dbSubnetGroup := range output.DBSubnetGroups {
+			if aws.ToString(dbSubnetGroup.VpcId) == vpcId {
+				dbSubnetGroups = append(dbSubnetGroups, dbSubnetGroup)
+			}
+		}
+		if output.Marker == nil {
+			return dbSubnetGroups, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// waitForDBClusterAvailable waits for the DB cluster dbClusterIdentifier to be
+// available, for up to databaseAvailableWaiterMaxDuration. The RDS API does not
+// provide a waiter for DB clusters.
+func waitForDBClusterAvailable(ctx context.Context, client *rds.Client, dbClusterIdentifier string) error {
+	var status string
+	err := poll(ctx, databasePollInterval, databaseAvailableWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		output, err := client.DescribeDBClusters(ctx, &rds.DescribeDBClustersInput{
+			DBClusterIdentifier: aws.String(dbClusterIdentifier),
+		})
+		if err != nil {
+			return false, err
+		}
+		status = ""
+		for _, dbCluster := range output.DBClusters {
+			status = aws.ToString(dbCluster.Status)
+		}
+		log.Info().
+			Str("DBClusterIdentifier", dbClusterIdentifier).
+			Str("Status", status).
+			Msg("DescribeDBClusters")
+		return status == "available", nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for DB cluster %s to be available: %s", dbClusterIdentifier, status)
+	}
+	return err
+}
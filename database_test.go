@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rds/types"
+)
+
+func TestDBClusterFinalSnapshotIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		dbCluster           types.DBCluster
+		finalSnapshotPrefix string
+		expected            string
+	}{
+		{
+			name: "no_prefix",
+			dbCluster: types.DBCluster{
+				DBClusterIdentifier: aws.String("cluster"),
+			},
+		},
+		{
+			name: "prefix",
+			dbCluster: types.DBCluster{
+				DBClusterIdentifier: aws.String("cluster"),
+			},
+			finalSnapshotPrefix: "final-",
+			expected:            "final-cluster",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := dbClusterFinalSnapshotIdentifier(tc.dbCluster, tc.finalSnapshotPrefix)
+			if aws.ToString(actual) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, aws.ToString(actual))
+			}
+		})
+	}
+}
+
+func TestDBInstanceFinalSnapshotIdentifier(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		dbInstance          types.DBInstance
+		finalSnapshotPrefix string
+		expected            string
+	}{
+		{
+			name: "no_prefix",
+			dbInstance: types.DBInstance{
+				DBInstanceIdentifier: aws.String("instance"),
+			},
+		},
+		{
+			name: "prefix",
+			dbInstance: types.DBInstance{
+				DBInstanceIdentifier: aws.String("instance"),
+			},
+			finalSnapshotPrefix: "final-",
+			expected:            "final-instance",
+		},
+		{
+			name: "cluster_member",
+			dbInstance: types.DBInstance{
+				DBClusterIdentifier:  aws.String("cluster"),
+				DBInstanceIdentifier: aws.String("instance"),
+			},
+			finalSnapshotPrefix: "final-",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := dbInstanceFinalSnapshotIdentifier(tc.dbInstance, tc.finalSnapshotPrefix)
+			if aws.ToString(actual) != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, aws.ToString(actual))
+			}
+		})
+	}
+}
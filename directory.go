@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/directoryservice"
+	"github.com/aws/aws-sdk-go-v2/service/directoryservice/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteDirectories deletes directories and waits for them to be deleted.
+func deleteDirectories(ctx context.Context, client *directoryservice.Client, directories []types.DirectoryDescription) error {
+	var errs error
+	deletingDirectoryIds := newStringSet()
+	for _, directory := range directories {
+		if directory.DirectoryId == nil {
+			continue
+		}
+		if directory.Stage == types.DirectoryStageDeleting {
+			deletingDirectoryIds[*directory.DirectoryId] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteDirectory(ctx, &directoryservice.DeleteDirectoryInput{
+			DirectoryId: directory.DirectoryId,
+		})
+		log.Err(err).
+			Str("DirectoryId", *directory.DirectoryId).
+			Msg("DeleteDirectory")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingDirectoryIds[*directory.DirectoryId] = struct{}{}
+		}
+	}
+	if len(deletingDirectoryIds) == 0 {
+		return errs
+	}
+
+	// Wait for the directories to be deleted.
+	var remaining []string
+	err := poll(ctx, directoryPollInterval, directoryDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		directories, err := listDirectories(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, directoryId := range directoryIds(directories) {
+			if deletingDirectoryIds.contains(directoryId) {
+				remaining = append(remaining, directoryId)
+			}
+		}
+		log.Info().
+			Strs("DirectoryIds", remaining).
+			Msg("DescribeDirectories")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for directories to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteDirectoriesInVpc deletes the Directory Service directories with
+// subnets in the VPC vpcId, waits for them to be deleted, and then waits for
+// Directory Service to delete the SecurityGroups that it created for them and
+// their NetworkInterfaces.
+func deleteDirectoriesInVpc(ctx context.Context, client *directoryservice.Client, ec2Client *ec2.Client, vpcId string) error {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+
+	directories, err := listDirectories(ctx, client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listDirectories")
+		return err
+	}
+	log.Info().
+		Strs("directoryIds", directoryIds(directories)).
+		Msg("listDirectories")
+	if len(directories) == 0 {
+		return nil
+	}
+
+	err = deleteDirectories(ctx, client, directories)
+	log.Err(err).
+		Strs("directoryIds", directoryIds(directories)).
+		Msg("deleteDirectories")
+	if err != nil {
+		return err
+	}
+
+	securityGroupIds := directorySecurityGroupIds(directories)
+	if len(securityGroupIds) == 0 {
+		return nil
+	}
+
+	err = waitForNetworkInterfacesDeleted(ctx, ec2Client, append(ec2VpcFilter(vpcId), ec2types.Filter{
+		Name:   aws.String("group-id"),
+		Values: securityGroupIds,
+	}), directoryDeletedWaiterMaxDuration)
+	log.Err(err).
+		Strs("securityGroupIds", securityGroupIds).
+		Msg("waitForNetworkInterfacesDeleted")
+	if err != nil {
+		return err
+	}
+
+	err = waitForSecurityGroupsDeleted(ctx, ec2Client, securityGroupIds, directoryDeletedWaiterMaxDuration)
+	log.Err(err).
+		Strs("securityGroupIds", securityGroupIds).
+		Msg("waitForSecurityGroupsDeleted")
+	return err
+}
+
+func directoryIds(directories []types.DirectoryDescription) []string {
+	directoryIds := make([]string, 0, len(directories))
+	for _, directory := range directories {
+		if directory.DirectoryId != nil {
+			directoryIds = append(directoryIds, *directory.DirectoryId)
+		}
+	}
+	return directoryIds
+}
+
+// directorySecurityGroupIds returns the SecurityGroups created by Directory
+// Service for directories.
+func directorySecurityGroupIds(directories []types.DirectoryDescription) []string {
+	var securityGroupIds []string
+	for _, directory := range directories {
+		if directory.VpcSettings != nil && directory.VpcSettings.SecurityGroupId != nil {
+			securityGroupIds = append(securityGroupIds, *directory.VpcSettings.SecurityGroupId)
+		}
+		if directory.ConnectSettings != nil && directory.ConnectSettings.SecurityGroupId != nil {
+			securityGroupIds = append(securityGroupIds, *directory.ConnectSettings.SecurityGroupId)
+		}
+	}
+	return securityGroupIds
+}
+
+// filterDirectoriesBySubnetIds returns the directories that have not been
+// deleted with a subnet in subnetIds, or all directories that have not been
+// deleted if subnetIds is nil.
+func filterDirectoriesBySubnetIds(directories []types.DirectoryDescription, subnetIds stringSet) []types.DirectoryDescription {
+	var filteredDirectories []types.DirectoryDescription
+DIRECTORY:
+	for _, directory := range directories {
+		if directory.Stage == types.DirectoryStageDeleted {
+			continue
+		}
+		if subnetIds == nil {
+			filteredDirectories = append(filteredDirectories, directory)
+			continue
+		}
+		var directorySubnetIds []string
+		if directory.VpcSettings != nil {
+			directorySubnetIds = append(directorySubnetIds, directory.VpcSettings.SubnetIds...)
+		}
+		if directory.ConnectSettings != nil {
+			directorySubnetIds = append(directorySubnetIds, directory.ConnectSettings.SubnetIds...)
+		}
+		for _, subnetId := range directorySubnetIds {
+			if subnetIds.contains(subnetId) {
+				filteredDirectories = append(filteredDirectories, directory)
+				continue DIRECTORY
+			}
+		}
+	}
+	return filteredDirectories
+}
+
+// listDirectories returns the directories that have not been deleted with a
+// subnet in subnetIds, or all directories that have not been deleted if
+// subnetIds is nil.
+func listDirectories(ctx context.Context, client *directoryservice.Client, subnetIds stringSet) ([]types.DirectoryDescription, error) {
+	input := directoryservice.DescribeDirectoriesInput{}
+	var directories []types.DirectoryDescription
+	for {
+		output, err := client.DescribeDirectories(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		directories = append(directories, filterDirectoriesBySubnetIds(output.DirectoryDescriptions, subnetIds)...)
+		if output.NextToken == nil {
+			return directories, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/directoryservice/types"
+)
+
+func TestFilterDirectoriesBySubnetIds(t *testing.T) {
+	directories := []types.DirectoryDescription{
+		{
+			DirectoryId: aws.String("d-no-settings"),
+		},
+		{
+			DirectoryId: aws.String("d-vpc-settings"),
+			VpcSettings: &types.DirectoryVpcSettingsDescription{
+				SubnetIds: []string{"subnet-1", "subnet-2"},
+			},
+		},
+		{
+			DirectoryId: aws.String("d-connect-settings"),
+			ConnectSettings: &types.DirectoryConnectSettingsDescription{
+				SubnetIds: []string{"subnet-3"},
+			},
+		},
+		{
+			DirectoryId: aws.String("d-deleted"),
+			Stage:       types.DirectoryStageDeleted,
+			VpcSettings: &types.DirectoryVpcSettingsDescription{
+				SubnetIds: []string{"subnet-1"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name                 string
+		subnetIds            stringSet
+		expectedDirectoryIds []string
+	}{
+		{
+			name:                 "nil",
+			expectedDirectoryIds: []string{"d-no-settings", "d-vpc-settings", "d-connect-settings"},
+		},
+		{
+			name:      "no_match",
+			subnetIds: newStringSet("subnet-4"),
+		},
+		{
+			name:                 "vpc_settings",
+			subnetIds:            newStringSet("subnet-1"),
+			expectedDirectoryIds: []string{"d-vpc-settings"},
+		},
+		{
+			name:                 "connect_settings",
+			subnetIds:            newStringSet("subnet-3"),
+			expectedDirectoryIds: []string{"d-connect-settings"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := filterDirectoriesBySubnetIds(directories, tc.subnetIds)
+			assertStrings(t, tc.expectedDirectoryIds, directoryIds(actual))
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/databasemigrationservice"
+	"github.com/aws/aws-sdk-go-v2/service/databasemigrationservice/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteReplicationInstances deletes replicationInstances and waits for them
+// to be deleted. Their replication tasks must already have been deleted.
+func deleteReplicationInstances(ctx context.Context, client *databasemigrationservice.Client, replicationInstances []types.ReplicationInstance) (errs error) {
+	var deletingReplicationInstanceArns []string
+	for _, replicationInstance := range replicationInstances {
+		if replicationInstance.ReplicationInstanceArn == nil {
+			continue
+		}
+		if aws.ToString(replicationInstance.ReplicationInstanceStatus) == "deleting" {
+			deletingReplicationInstanceArns = append(deletingReplicationInstanceArns, *replicationInstance.ReplicationInstanceArn)
+			continue
+		}
+		_, err := client.DeleteReplicationInstance(ctx, &databasemigrationservice.DeleteReplicationInstanceInput{
+			ReplicationInstanceArn: replicationInstance.ReplicationInstanceArn,
+		})
+		log.Err(err).
+			Str("ReplicationInstanceArn", *replicationInstance.ReplicationInstanceArn).
+			Msg("DeleteReplicationInstance")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingReplicationInstanceArns = append(deletingReplicationInstanceArns, *replicationInstance.ReplicationInstanceArn)
+		}
+	}
+
+	// Wait for the replication instances to be deleted.
+	replicationInstanceDeletedWaiter := databasemigrationservice.NewReplicationInstanceDeletedWaiter(client)
+	for _, replicationInstanceArn := range deletingReplicationInstanceArns {
+		log.Info().
+			Str("ReplicationInstanceArn", replicationInstanceArn).
+			Msg("ReplicationInstanceDeletedWaiter.Wait")
+		err := replicationInstanceDeletedWaiter.Wait(ctx, &databasemigrationservice.DescribeReplicationInstancesInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("replication-instance-arn"),
+					Values: []string{replicationInstanceArn},
+				},
+			},
+		}, replicationInstanceDeletedWaiterMaxDuration)
+		log.Err(err).
+			Str("ReplicationInstanceArn", replicationInstanceArn).
+			Msg("ReplicationInstanceDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// deleteReplicationInstancesInVpc deletes the DMS replication instances in the
+// VPC vpcId together with their replication tasks, waits for them to be
+// deleted, and then deletes the VPC's replication subnet groups. It
+// accumulates errors.
+func deleteReplicationInstancesInVpc(ctx context.Context, client *databasemigrationservice.Client, vpcId string) (errs error) {
+	replicationInstances, err := listReplicationInstances(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listReplicationInstances")
+		return err
+	}
+	log.Info().
+		Strs("replicationInstanceArns", replicationInstanceArns(replicationInstances)).
+		Msg("listReplicationInstances")
+	if len(replicationInstances) > 0 {
+		replicationTasks, err := listReplicationTasks(ctx, client, replicationInstanceArns(replicationInstances))
+		if err != nil {
+			log.Err(err).
+				Msg("listReplicationTasks")
+			return err
+		}
+		log.Info().
+			Strs("replicationTaskArns", replicationTaskArns(replicationTasks)).
+			Msg("listReplicationTasks")
+		if len(replicationTasks) > 0 {
+			err := deleteReplicationTasks(ctx, client, replicationTasks)
+			log.Err(err).
+				Strs("replicationTaskArns", replicationTaskArns(replicationTasks)).
+				Msg("deleteReplicationTasks")
+			if err != nil {
+				return err
+			}
+		}
+
+		err = deleteReplicationInstances(ctx, client, replicationInstances)
+		log.Err(err).
+			Strs("replicationInstanceArns", replicationInstanceArns(replicationInstances)).
+			Msg("deleteReplicationInstances")
+		if err != nil {
+			return err
+		}
+	}
+
+	replicationSubnetGroups, err := listReplicationSubnetGroups(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listReplicationSubnetGroups")
+		return err
+	}
+	log.Info().
+		Strs("replicationSubnetGroupIdentifiers", replicationSubnetGroupIdentifiers(replicationSubnetGroups)).
+		Msg("listReplicationSubnetGroups")
+	if len(replicationSubnetGroups) > 0 {
+		err := deleteReplicationSubnetGroups(ctx, client, replicationSubnetGroups)
+		log.Err(err).
+			Strs("replicationSubnetGroupIdentifiers", replicationSubnetGroupIdentifiers(replicationSubnetGroups)).
+			Msg("deleteReplicationSubnetGroups")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+func deleteReplicationSubnetGroups(ctx context.Context, client *databasemigrationservice.Client, replicationSubnetGroups []types.ReplicationSubnetGroup) (errs error) {
+	for _, replicationSubnetGroup := range replicationSubnetGroups {
+		if replicationSubnetGroup.ReplicationSubnetGroupIdentifier == nil {
+			continue
+		}
+		_, err := client.DeleteReplicationSubnetGroup(ctx, &databasemigrationservice.DeleteReplicationSubnetGroupInput{
+			ReplicationSubnetGroupIdentifier: replicationSubnetGroup.ReplicationSubnetGroupIdentifier,
+		})
+		log.Err(err).
+			Str("ReplicationSubnetGroupIdentifier", *replicationSubnetGroup.ReplicationSubnetGroupIdentifier).
+			Msg("DeleteReplicationSubnetGroup")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteReplicationTasks stops the running replicationTasks, waits for them to
+// stop, and then deletes replicationTasks and waits for them to be deleted.
+func deleteReplicationTasks(ctx context.Context, client *databasemigrationservice.Client, replicationTasks []types.ReplicationTask) (errs error) {
+	// Stop the running replication tasks.
+	var stoppingReplicationTaskArns []string
+	for _, replicationTask := range replicationTasks {
+		if replicationTask.ReplicationTaskArn == nil {
+			continue
+		}
+		switch aws.ToString(replicationTask.Status) {
+		case "running":
+			_, err := client.StopReplicationTask(ctx, &databasemigrationservice.StopReplicationTaskInput{
+				ReplicationTaskArn: replicationTask.ReplicationTaskArn,
+			})
+			log.Err(err).
+				Str("ReplicationTaskArn", *replicationTask.ReplicationTaskArn).
+				Msg("StopReplicationTask")
+			errs = multierr.Append(errs, err)
+			if err == nil {
+				stoppingReplicationTaskArns = append(stoppingReplicationTaskArns, *replicationTask.ReplicationTaskArn)
+			}
+		case "stopping":
+			stoppingReplicationTaskArns = append(stoppingReplicationTaskArns, *replicationTask.ReplicationTaskArn)
+		}
+	}
+
+	// Wait for the replication tasks to stop.
+	replicationTaskStoppedWaiter := databasemigrationservice.NewReplicationTaskStoppedWaiter(client)
+	for _, replicationTaskArn := range stoppingReplicationTaskArns {
+		log.Info().
+			Str("ReplicationTaskArn", replicationTaskArn).
+			Msg("ReplicationTaskStoppedWaiter.Wait")
+		err := replicationTaskStoppedWaiter.Wait(ctx, &databasemigrationservice.DescribeReplicationTasksInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("replication-task-arn"),
+					Values: []string{replicationTaskArn},
+				},
+			},
+		}, replicationTaskWaiterMaxDuration)
+		log.Err(err).
+			Str("ReplicationTaskArn", replicationTaskArn).
+			Msg("ReplicationTaskStoppedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return
+	}
+
+	// Delete the replication tasks.
+	var deletingReplicationTaskArns []string
+	for _, replicationTask := range replicationTasks {
+		if replicationTask.ReplicationTaskArn == nil {
+			continue
+		}
+		if aws.ToString(replicationTask.Status) == "deleting" {
+			deletingReplicationTaskArns = append(deletingReplicationTaskArns, *replicationTask.ReplicationTaskArn)
+			continue
+		}
+		_, err := client.DeleteReplicationTask(ctx, &databasemigrationservice.DeleteReplicationTaskInput{
+			ReplicationTaskArn: replicationTask.ReplicationTaskArn,
+		})
+		log.Err(err).
+			Str("ReplicationTaskArn", *replicationTask.ReplicationTaskArn).
+			Msg("DeleteReplicationTask")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingReplicationTaskArns = append(deletingReplicationTaskArns, *replicationTask.ReplicationTaskArn)
+		}
+	}
+
+	// Wait for the replication tasks to be deleted.
+	replicationTaskDeletedWaiter := databasemigrationservice.NewReplicationTaskDeletedWaiter(client)
+	for _, replicationTaskArn := range deletingReplicationTaskArns {
+		log.Info().
+			Str("ReplicationTaskArn", replicationTaskArn).
+			Msg("ReplicationTaskDeletedWaiter.Wait")
+		err := replicationTaskDeletedWaiter.Wait(ctx, &databasemigrationservice.DescribeReplicationTasksInput{
+			Filters: []types.Filter{
+				{
+					Name:   aws.String("replication-task-arn"),
+					Values: []string{replicationTaskArn},
+				},
+			},
+		}, replicationTaskWaiterMaxDuration)
+		log.Err(err).
+			Str("ReplicationTaskArn", replicationTaskArn).
+			Msg("ReplicationTaskDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// listReplicationInstances returns the DMS replication instances whose
+// replication subnet group is in the VPC vpcId.
+func listReplicationInstances(ctx context.Context, client *databasemigrationservice.Client, vpcId string) ([]types.ReplicationInstance, error) {
+	input := databasemigrationservice.DescribeReplicationInstancesInput{}
+	var replicationInstances []types.ReplicationInstance
+	for {
+		output, err := client.DescribeReplicationInstances(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, replicationInstance := range output.ReplicationInstances {
+			if replicationInstance.ReplicationSubnetGroup != nil && aws.ToString(replicationInstance.ReplicationSubnetGroup.VpcId) == vpcId {
+				replicationInstances = append(replicationInstances, replicationInstance)
+			}
+		}
+		if output.Marker == nil {
+			return replicationInstances, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+func listReplicationSubnetGroups(ctx context.Context, client *databasemigrationservice.Client, vpcId string) ([]types.ReplicationSubnetGroup, error) {
+	input := databasemigrationservice.DescribeReplicationSubnetGroupsInput{}
+	var replicationSubnetGroups []types.ReplicationSubnetGroup
+	for {
+		output, err := client.DescribeReplicationSubnetGroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, replicationSubnetGroup := range output.ReplicationSubnetGroups {
+			if aws.ToString(replicationSubnetGroup.VpcId) == vpcId {
+				replicationSubnetGroups = append(replicationSubnetGroups, replicationSubnetGroup)
+			}
+		}
+		if output.Marker == nil {
+			return replicationSubnetGroups, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// listReplicationTasks returns the DMS replication tasks of the replication
+// instances replicationInstanceArns.
+func listReplicationTasks(ctx context.Context, client *databasemigrationservice.Client, replicationInstanceArns []string) ([]types.ReplicationTask, error) {
+	input := databasemigrationservice.DescribeReplicationTasksInput{
+		Filters: []types.Filter{
+			{
+				Name:   aws.String("replication-instance-arn"),
+				Values: replicationInstanceArns,
+			},
+		},
+		WithoutSettings: aws.Bool(true),
+	}
+	var replicationTasks []types.ReplicationTask
+	for {
+		output, err := client.DescribeReplicationTasks(ctx, &input)
+		if err != nil {
+			var resourceNotFoundFault *types.ResourceNotFoundFault
+			if errors.As(err, &resourceNotFoundFault) {
+				return replicationTasks, nil
+			}
+			return nil, err
+		}
+		replicationTasks = append(replicationTasks, output.ReplicationTasks...)
+		if output.Marker == nil {
+			return replicationTasks, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+func replicationInstanceArns(replicationInstances []types.ReplicationInstance) []string {
+	replicationInstanceArns := make([]string, 0, len(replicationInstances))
+	for _, replicationInstance := range replicationInstances {
+		if replicationInstance.ReplicationInstanceArn != nil {
+			replicationInstanceArns = append(replicationInstanceArns, *replicationInstance.ReplicationInstanceArn)
+		}
+	}
+	return replicationInstanceArns
+}
+
+func replicationSubnetGroupIdentifiers(replicationSubnetGroups []types.ReplicationSubnetGroup) []string {
+	replicationSubnetGroupIdentifiers := make([]string, 0, len(replicationSubnetGroups))
+	for _, replicationSubnetGroup := range replicationSubnetGroups {
+		if replicationSubnetGroup.ReplicationSubnetGroupIdentifier != nil {
+			replicationSubnetGroupIdentifiers = append(replicationSubnetGroupIdentifiers, *replicationSubnetGroup.ReplicationSubnetGroupIdentifier)
+		}
+	}
+	return replicationSubnetGroupIdentifiers
+}
+
+func replicationTaskArns(replicationTasks []types.ReplicationTask) []string {
+	replicationTaskArns := make([]string, 0, len(replicationTasks))
+	for _, replicationTask := range replicationTasks {
+		if replicationTask.ReplicationTaskArn != nil {
+			replicationTaskArns = append(replicationTaskArns, *replicationTask.ReplicationTaskArn)
+		}
+	}
+	return replicationTaskArns
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+const (
+	// ecsDescribeClustersMaxClusters is the maximum number of clusters
+	// accepted by a single DescribeClusters call.
+	ecsDescribeClustersMaxClusters = 100
+
+	// ecsDescribeServicesMaxServices is the maximum number of services
+	// accepted by a single DescribeServices call.
+	ecsDescribeServicesMaxServices = 10
+
+	// ecsDescribeTasksMaxTasks is the maximum number of tasks accepted by a
+	// single DescribeTasks call.
+	ecsDescribeTasksMaxTasks = 100
+)
+
+// deleteEcsClustersIfEmpty deletes the ECS clusters clusterArns that have no
+// services, tasks, or container instances.
+func deleteEcsClustersIfEmpty(ctx context.Context, client *ecs.Client, clusterArns []string) (errs error) {
+	var clusters []types.Cluster
+	for start := 0; start < len(clusterArns); start += ecsDescribeClustersMaxClusters {
+		end := start + ecsDescribeClustersMaxClusters
+		if end > len(clusterArns) {
+			end = len(clusterArns)
+		}
+		output, err := client.DescribeClusters(ctx, &ecs.DescribeClustersInput{
+			Clusters: clusterArns[start:end],
+		})
+		if err != nil {
+			return err
+		}
+		clusters = append(clusters, output.Clusters...)
+	}
+	for _, cluster := range clusters {
+		if cluster.ClusterArn == nil {
+			continue
+		}
+		if cluster.ActiveServicesCount != 0 || cluster.PendingTasksCount != 0 || cluster.RunningTasksCount != 0 || cluster.RegisteredContainerInstancesCount != 0 {
+			log.Info().
+				Str("ClusterArn", *cluster.ClusterArn).
+				Msg("skipping non-empty ECS cluster")
+			continue
+		}
+		_, err := client.DeleteCluster(ctx, &ecs.DeleteClusterInput{
+			Cluster: cluster.ClusterArn,
+		})
+		log.Err(err).
+			Str("ClusterArn", *cluster.ClusterArn).
+			Msg("DeleteCluster")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteEcsServices scales services to zero and deletes them.
+func deleteEcsServices(ctx context.Context, client *ecs.Client, services []types.Service) (errs error) {
+	for _, service := range services {
+		if service.ServiceArn == nil || aws.ToString(service.Status) != "ACTIVE" {
+			continue
+		}
+
+		// Scale the service to zero so that it does not replace its tasks.
+		if service.DesiredCount != 0 {
+			_, err := client.UpdateService(ctx, &ecs.UpdateServiceInput{
+				Cluster:      service.ClusterArn,
+				Service:      service.ServiceArn,
+				DesiredCount: aws.Int32(0),
+			})
+			log.Err(err).
+				Str("ServiceArn", *service.ServiceArn).
+				Msg("UpdateService")
+			errs = multierr.Append(errs, err)
+		}
+
+		// Delete the service.
+		_, err := client.DeleteService(ctx, &ecs.DeleteServiceInput{
+			Cluster: service.ClusterArn,
+			Service: service.ServiceArn,
+			Force:   aws.Bool(true),
+		})
+		log.Err(err).
+			Str("ServiceArn", *service.ServiceArn).
+			Msg("DeleteService")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteEcsServicesInVpc deletes the ECS services, and stops the ECS tasks,
+// that use awsvpc networking in the VPC vpcId, and waits for the tasks'
+// NetworkInterfaces to be deleted. If opts.deleteEcsClusters is true then ECS
+// clusters that are left empty are deleted. It accumulates errors.
+func deleteEcsServicesInVpc(ctx context.Context, client *ecs.Client, ec2Client *ec2.Client, vpcId string, opts *options) (errs error) {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+	securityGroups, err := listNonDefaultSecurityGroups(ctx, ec2Client, ec2VpcFilter(vpcId))
+	if err != nil {
+		log.Err(err).
+			Msg("listNonDefaultSecurityGroups")
+		return err
+	}
+	vpcSubnetIds := newStringSet(subnetIds(subnets)...)
+	vpcSecurityGroupIds := newStringSet(securityGroupIds(securityGroups)...)
+
+	// The tasks' attachments do not include their SecurityGroups, so find the
+	// NetworkInterfaces that use the VPC's SecurityGroups instead.
+	var securityGroupNetworkInterfaces []ec2types.NetworkInterface
+	if len(securityGroups) > 0 {
+		securityGroupNetworkInterfaces, err = listNetworkInterfaces(ctx, ec2Client, []ec2types.Filter{
+			{
+				Name:   aws.String("group-id"),
+				Values: securityGroupIds(securityGroups),
+			},
+		})
+		if err != nil {
+			log.Err(err).
+				Msg("listNetworkInterfaces")
+			return err
+		}
+	}
+	securityGroupNetworkInterfaceIds := newStringSet(networkInterfaceIds(securityGroupNetworkInterfaces)...)
+
+	clusterArns, err := listEcsClusterArns(ctx, client)
+	if err != nil {
+		log.Err(err).
+			Msg("listEcsClusterArns")
+		return err
+	}
+
+	var affectedClusterArns []string
+	var networkInterfaceIds []string
+	for _, clusterArn := range clusterArns {
+		services, err := listEcsServices(ctx, client, clusterArn, vpcSubnetIds, vpcSecurityGroupIds)
+		if err != nil {
+			log.Err(err).
+				Str("clusterArn", clusterArn).
+				Msg("listEcsServices")
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		log.Info().
+			Str("clusterArn", clusterArn).
+			Strs("serviceArns", ecsServiceArns(services)).
+			Msg("listEcsServices")
+		if len(services) > 0 {
+			err := deleteEcsServices(ctx, client, services)
+			log.Err(err).
+				Strs("serviceArns", ecsServiceArns(services)).
+				Msg("deleteEcsServices")
+			errs = multierr.Append(errs, err)
+		}
+
+		tasks, err := listEcsTasks(ctx, client, clusterArn, vpcSubnetIds, securityGroupNetworkInterfaceIds)
+		if err != nil {
+			log.Err(err).
+				Str("clusterArn", clusterArn).
+				Msg("listEcsTasks")
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		log.Info().
+			Str("clusterArn", clusterArn).
+			Strs("taskArns", ecsTaskArns(tasks)).
+			Msg("listEcsTasks")
+		if len(tasks) > 0 {
+			err := stopEcsTasks(ctx, client, tasks)
+			log.Err(err).
+				Strs("taskArns", ecsTaskArns(tasks)).
+				Msg("stopEcsTasks")
+			errs = multierr.Append(errs, err)
+			networkInterfaceIds = append(networkInterfaceIds, ecsTaskNetworkInterfaceIds(tasks)...)
+		}
+
+		if len(services) > 0 || len(tasks) > 0 {
+			affectedClusterArns = append(affectedClusterArns, clusterArn)
+		}
+	}
+
+	// Wait for the tasks' NetworkInterfaces to be deleted.
+	if len(networkInterfaceIds) > 0 {
+		err := waitForNetworkInterfacesDeleted(ctx, ec2Client, []ec2types.Filter{
+			{
+				Name:   aws.String("network-interface-id"),
+				Values: networkInterfaceIds,
+			},
+		}, ecsTasksStoppedWaiterMaxDuration)
+		log.Err(err).
+			Strs("networkInterfaceIds", networkInterfaceIds).
+			Msg("waitForNetworkInterfacesDeleted")
+		errs = multierr.Append(errs, err)
+	}
+
+	if opts.deleteEcsClusters && len(affectedClusterArns) > 0 {
+		err := deleteEcsClustersIfEmpty(ctx, client, affectedClusterArns)
+		log.Err(err).
+			Strs("clusterArns", affectedClusterArns).
+			Msg("deleteEcsClustersIfEmpty")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+func ecsServiceArns(services []types.Service) []string {
+	serviceArns := make([]string, 0, len(services))
+	for _, service := range services {
+		if service.ServiceArn != nil {
+			serviceArns = append(serviceArns, *service.ServiceArn)
+		}
+	}
+	return serviceArns
+}
+
+func ecsTaskArns(tasks []types.Task) []string {
+	taskArns := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		if task.TaskArn != nil {
+			taskArns = append(taskArns, *task.TaskArn)
+		}
+	}
+	return taskArns
+}
+
+// ecsTaskAttachmentDetail returns the value of the detail name of task's
+// ElasticNetworkInterface attachment, or the empty string if there is none.
+func ecsTaskAttachmentDetail(task types.Task, name string) string {
+	for _, attachment := range task.Attachments {
+		if aws.ToString(attachment.Type) != "ElasticNetworkInterface" {
+			continue
+		}
+		for _, detail := range attachment.Details {
+			if aws.ToString(detail.Name) == name {
+				return aws.ToString(detail.Value)
+			}
+		}
+	}
+	return ""
+}
+
+func ecsTaskNetworkInterfaceIds(tasks []types.Task) []string {
+	networkInterfaceIds := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		if networkInterfaceId := ecsTaskAttachmentDetail(task, "networkInterfaceId"); networkInterfaceId != "" {
+			networkInterfaceIds = append(networkInterfaceIds, networkInterfaceId)
+		}
+	}
+	return networkInterfaceIds
+}
+
+func listEcsClusterArns(ctx context.Context, client *ecs.Client) ([]string, error) {
+	input := ecs.ListClustersInput{}
+	var clusterArns []string
+	for {
+		output, err := client.ListClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		clusterArns = append(clusterArns, output.ClusterArns...)
+		if output.NextToken == nil {
+			return clusterArns, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listEcsServices returns the services in the ECS cluster clusterArn whose
+// awsvpc network configuration refers to any of subnetIds or
+// securityGroupIds.
+func listEcsServices(ctx context.Context, client *ecs.Client, clusterArn string, subnetIds, securityGroupIds stringSet) ([]types.Service, error) {
+	input := ecs.ListServicesInput{
+		Cluster:    aws.String(clusterArn),
+		MaxResults: aws.Int32(ecsDescribeServicesMaxServices),
+	}
+	var services []types.Service
+	for {
+		output, err := client.ListServices(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		if len(output.ServiceArns) > 0 {
+			describeServicesOutput, err := client.DescribeServices(ctx, &ecs.DescribeServicesInput{
+				Cluster:  aws.String(clusterArn),
+				Services: output.ServiceArns,
+			})
+			if err != nil {
+				return nil, err
+			}
+		SERVICE:
+			for _, service := range describeServicesOutput.Services {
+				if service.NetworkConfiguration == nil || service.NetworkConfiguration.AwsvpcConfiguration == nil {
+					continue
+				}
+				awsvpcConfiguration := service.NetworkConfiguration.AwsvpcConfiguration
+				for _, subnetId := range awsvpcConfiguration.Subnets {
+					if subnetIds.contains(subnetId) {
+						services = append(services, service)
+						continue SERVICE
+					}
+				}
+				for _, securityGroupId := range awsvpcConfiguration.SecurityGroups {
+					if securityGroupIds.contains(securityGroupId) {
+						services = append(services, service)
+						continue SERVICE
+					}
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return services, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listEcsTasks returns the running tasks in the ECS cluster clusterArn, both
+// standalone and started by services, whose NetworkInterface is in any of
+// subnetIds or is any of securityGroupNetworkInterfaceIds, the
+// NetworkInterfaces that use the SecurityGroups of interest.
+func listEcsTasks(ctx context.Context, client *ecs.Client, clusterArn string, subnetIds, securityGroupNetworkInterfaceIds stringSet) ([]types.Task, error) {
+	input := ecs.ListTasksInput{
+		Cluster:    aws.String(clusterArn),
+		MaxResults: aws.Int32(ecsDescribeTasksMaxTasks),
+	}
+	var tasks []types.Task
+	for {
+		output, err := client.ListTasks(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		if len(output.TaskArns) > 0 {
+			describeTasksOutput, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+				Cluster: aws.String(clusterArn),
+				Tasks:   output.TaskArns,
+			})
+			if err != nil {
+				return nil, err
+			}
+			for _, task := range describeTasksOutput.Tasks {
+				if subnetIds.contains(ecsTaskAttachmentDetail(task, "subnetId")) || securityGroupNetworkInterfaceIds.contains(ecsTaskAttachmentDetail(task, "networkInterfaceId")) {
+					tasks = append(tasks, task)
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return tasks, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// stopEcsTasks stops tasks.
+func stopEcsTasks(ctx context.Context, client *ecs.Client, tasks []types.Task) (errs error) {
+	for _, task := range tasks {
+		if task.TaskArn == nil || aws.ToString(task.DesiredStatus) == "STOPPED" {
+			continue
+		}
+		_, err := client.StopTask(ctx, &ecs.StopTaskInput{
+			Cluster: task.ClusterArn,
+			Task:    task.TaskArn,
+			Reason:  aws.String("Stopped by aws-delete-vpc"),
+		})
+		log.Err(err).
+			Str("TaskArn", *task.TaskArn).
+			Msg("StopTask")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
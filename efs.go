@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
+	"github.com/aws/aws-sdk-go-v2/service/efs/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteEfsFileSystems deletes fileSystems. Their mount targets must already
+// have been deleted.
+func deleteEfsFileSystems(ctx context.Context, client *efs.Client, fileSystems []types.FileSystemDescription) (errs error) {
+	for _, fileSystem := range fileSystems {
+		if fileSystem.FileSystemId == nil {
+			continue
+		}
+		_, err := client.DeleteFileSystem(ctx, &efs.DeleteFileSystemInput{
+			FileSystemId: fileSystem.FileSystemId,
+		})
+		log.Err(err).
+			Str("FileSystemId", *fileSystem.FileSystemId).
+			Msg("DeleteFileSystem")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteEfsInVpc deletes the EFS mount targets in the VPC vpcId and waits for
+// their NetworkInterfaces to be deleted. If opts.deleteEfsFileSystems is true
+// and clusterName is set then the file systems owned by the cluster that have
+// no mount targets outside the VPC are then deleted too, including those whose
+// mount targets were deleted in earlier tries. It accumulates errors.
+func deleteEfsInVpc(ctx context.Context, client *efs.Client, ec2Client *ec2.Client, clusterName, vpcId string, opts *options) (errs error) {
+	fileSystems, err := listEfsFileSystems(ctx, client)
+	if err != nil {
+		log.Err(err).
+			Msg("listEfsFileSystems")
+		return err
+	}
+
+	mountTargets, err := listEfsMountTargets(ctx, client, fileSystems, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listEfsMountTargets")
+		return err
+	}
+	log.Info().
+		Strs("mountTargetIds", efsMountTargetIds(mountTargets)).
+		Msg("listEfsMountTargets")
+	if len(mountTargets) > 0 {
+		err := deleteEfsMountTargets(ctx, client, ec2Client, mountTargets)
+		log.Err(err).
+			Strs("mountTargetIds", efsMountTargetIds(mountTargets)).
+			Msg("deleteEfsMountTargets")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !opts.deleteEfsFileSystems || clusterName == "" {
+		return
+	}
+
+	ownedFileSystems := selectEfsFileSystemsOwnedByCluster(fileSystems, mountTargets, clusterName)
+	log.Info().
+		Strs("fileSystemIds", efsFileSystemIds(ownedFileSystems)).
+		Msg("listEfsFileSystems")
+	if len(ownedFileSystems) > 0 {
+		err := deleteEfsFileSystems(ctx, client, ownedFileSystems)
+		log.Err(err).
+			Strs("fileSystemIds", efsFileSystemIds(ownedFileSystems)).
+			Msg("deleteEfsFileSystems")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// deleteEfsMountTargets deletes mountTargets and waits for their
+// NetworkInterfaces to be deleted.
+func deleteEfsMountTargets(ctx context.Context, client *efs.Client, ec2Client *ec2.Client, mountTargets []types.MountTargetDescription) error {
+	var errs error
+	var networkInterfaceIds []string
+	for _, mountTarget := range mountTargets {
+		if mountTarget.MountTargetId == nil {
+			continue
+		}
+		if mountTarget.LifeCycleState != types.LifeCycleStateDeleting && mountTarget.LifeCycleState != types.LifeCycleStateDeleted {
+			_, err := client.DeleteMountTarget(ctx, &efs.DeleteMountTargetInput{
+				MountTargetId: mountTarget.MountTargetId,
+			})
+			log.Err(err).
+				Str("MountTargetId", *mountTarget.MountTargetId).
+				Msg("DeleteMountTarget")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+		}
+		if mountTarget.NetworkInterfaceId != nil {
+			networkInterfaceIds = append(networkInterfaceIds, *mountTarget.NetworkInterfaceId)
+		}
+	}
+	if len(networkInterfaceIds) == 0 {
+		return errs
+	}
+
+	err := waitForNetworkInterfacesDeleted(ctx, ec2Client, []ec2types.Filter{
+		{
+			Name:   aws.String("network-interface-id"),
+			Values: networkInterfaceIds,
+		},
+	}, efsMountTargetDeletedWaiterMaxDuration)
+	log.Err(err).
+		Strs("networkInterfaceIds", networkInterfaceIds).
+		Msg("waitForNetworkInterfacesDeleted")
+	return multierr.Append(errs, err)
+}
+
+func efsFileSystemIds(fileSystems []types.FileSystemDescription) []string {
+	fileSystemIds := make([]string, 0, len(fileSystems))
+	for _, fileSystem := range fileSystems {
+		if fileSystem.FileSystemId != nil {
+			fileSystemIds = append(fileSystemIds, *fileSystem.FileSystemId)
+		}
+	}
+	return fileSystemIds
+}
+
+func efsMountTargetIds(mountTargets []types.MountTargetDescription) []string {
+	mountTargetIds := make([]string, 0, len(mountTargets))
+	for _, mountTarget := range mountTargets {
+		if mountTarget.MountTargetId != nil {
+			mountTargetIds = append(mountTargetIds, *mountTarget.MountTargetId)
+		}
+	}
+	return mountTargetIds
+}
+
+// isEfsFileSystemOwnedByCluster returns whether fileSystem is tagged as owned
+// by the cluster clusterName.
+func isEfsFileSystemOwnedByCluster(fileSystem types.FileSystemDescription, clusterName string) bool {
+	tagKeys := newStringSet(kubernetesClusterTagKeys(clusterName)...)
+	for _, tag := range fileSystem.Tags {
+		if tag.Key != nil && tagKeys.contains(*tag.Key) && aws.ToString(tag.Value) == kubernetesClusterTagValueOwned {
+			return true
+		}
+	}
+	return false
+}
+
+// selectEfsFileSystemsOwnedByCluster returns the fileSystems that are tagged as
+// owned by the cluster clusterName and are not being deleted. File systems with
+// more mount targets than those of them in vpcMountTargets, the mount targets
+// in the VPC, have mount targets in other VPCs and are skipped.
+func selectEfsFileSystemsOwnedByCluster(fileSystems []types.FileSystemDescription, vpcMountTargets []types.MountTargetDescription, clusterName string) []types.FileSystemDescription {
+	vpcMountTargetCounts := make(map[string]int32)
+	for _, mountTarget := range vpcMountTargets {
+		if mountTarget.FileSystemId != nil {
+			vpcMountTargetCounts[*mountTarget.FileSystemId]++
+		}
+	}
+	var ownedFileSystems []types.FileSystemDescription
+	for _, fileSystem := range fileSystems {
+		if fileSystem.FileSystemId == nil || !isEfsFileSystemOwnedByCluster(fileSystem, clusterName) {
+			continue
+		}
+		if fileSystem.LifeCycleState == types.LifeCycleStateDeleting || fileSystem.LifeCycleState == types.LifeCycleStateDeleted {
+			continue
+		}
+		if fileSystem.NumberOfMountTargets != vpcMountTargetCounts[*fileSystem.FileSystemId] {
+			log.Info().
+				Str("FileSystemId", *fileSystem.FileSystemId).
+				Msg("skipping EFS file system with mount targets in other VPCs")
+			continue
+		}
+		ownedFileSystems = append(ownedFileSystems, fileSystem)
+	}
+	return ownedFileSystems
+}
+
+func listEfsFileSystems(ctx context.Context, client *efs.Client) ([]types.FileSystemDescription, error) {
+	input := efs.DescribeFileSystemsInput{}
+	var fileSystems []types.FileSystemDescription
+	for {
+		output, err := client.DescribeFileSystems(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		fileSystems = append(fileSystems, output.FileSystems...)
+		if output.NextMarker == nil {
+			return fileSystems, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+// listEfsMountTargets returns the mount targets of fileSystems that are in the
+// VPC vpcId.
+func listEfsMountTargets(ctx context.Context, client *efs.Client, fileSystems []types.FileSystemDescription, vpcId string) ([]types.MountTargetDescription, error) {
+	var mountTargets []types.MountTargetDescription
+	for _, fileSystem := range fileSystems {
+		if fileSystem.FileSystemId == nil || fileSystem.NumberOfMountTargets == 0 {
+			continue
+		}
+		input := efs.DescribeMountTargetsInput{
+			FileSystemId: fileSystem.FileSystemId,
+		}
+		for {
+			output, err := client.DescribeMountTargets(ctx, &input)
+			if err != nil {
+				return nil, err
+			}
+			for _, mountTarget := range output.MountTargets {
+				if aws.ToString(mountTarget.VpcId) == vpcId {
+					mountTargets = append(mountTargets, mountTarget)
+				}
+			}
+			if output.NextMarker == nil {
+				break
+			}
+			input.Marker = output.NextMarker
+		}
+	}
+	return mountTargets, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/efs/types"
+)
+
+func TestSelectEfsFileSystemsOwnedByCluster(t *testing.T) {
+	fileSystem := func(fileSystemId, tagKey, tagValue string, lifeCycleState types.LifeCycleState, numberOfMountTargets int32) types.FileSystemDescription {
+		return types.FileSystemDescription{
+			FileSystemId:         aws.String(fileSystemId),
+			LifeCycleState:       lifeCycleState,
+			NumberOfMountTargets: numberOfMountTargets,
+			Tags: []types.Tag{
+				{Key: aws.String(tagKey), Value: aws.String(tagValue)},
+			},
+		}
+	}
+	mountTarget := func(fileSystemId string) types.MountTargetDescription {
+		return types.MountTargetDescription{
+			FileSystemId: aws.String(fileSystemId),
+		}
+	}
+
+	fileSystems := []types.FileSystemDescription{
+		fileSystem("fs-owned", "kubernetes.io/cluster/test", "owned", types.LifeCycleStateAvailable, 2),
+		fileSystem("fs-owned-k8s-io", "k8s.io/cluster/test", "owned", types.LifeCycleStateAvailable, 0),
+		fileSystem("fs-shared", "kubernetes.io/cluster/test", "shared", types.LifeCycleStateAvailable, 1),
+		fileSystem("fs-other-cluster", "kubernetes.io/cluster/other", "owned", types.LifeCycleStateAvailable, 1),
+		fileSystem("fs-deleting", "kubernetes.io/cluster/test", "owned", types.LifeCycleStateDeleting, 1),
+		fileSystem("fs-other-vpc", "kubernetes.io/cluster/test", "owned", types.LifeCycleStateAvailable, 2),
+	}
+
+	for _, tc := range []struct {
+		name                  string
+		vpcMountTargets       []types.MountTargetDescription
+		clusterName           string
+		expectedFileSystemIds []string
+	}{
+		{
+			name:        "no_vpc_mount_targets",
+			clusterName: "test",
+			expectedFileSystemIds: []string{
+				"fs-owned-k8s-io",
+			},
+		},
+		{
+			name: "vpc_mount_targets",
+			vpcMountTargets: []types.MountTargetDescription{
+				mountTarget("fs-owned"),
+				mountTarget("fs-owned"),
+				mountTarget("fs-shared"),
+				mountTarget("fs-other-cluster"),
+				mountTarget("fs-deleting"),
+				mountTarget("fs-other-vpc"),
+			},
+			clusterName: "test",
+			expectedFileSystemIds: []string{
+				"fs-owned",
+				"fs-owned-k8s-io",
+			},
+		},
+		{
+			name:        "other_cluster",
+			clusterName: "other",
+			vpcMountTargets: []types.MountTargetDescription{
+				mountTarget("fs-other-cluster"),
+			},
+			expectedFileSystemIds: []string{
+				"fs-other-cluster",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := selectEfsFileSystemsOwnedByCluster(fileSystems, tc.vpcMountTargets, tc.clusterName)
+			assertStrings(t, tc.expectedFileSystemIds, efsFileSystemIds(actual))
+		})
+	}
+}
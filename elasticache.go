@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func cacheClusterIds(cacheClusters []types.CacheCluster) []string {
+	cacheClusterIds := make([]string, 0, len(cacheClusters))
+	for _, cacheCluster := range cacheClusters {
+		if cacheCluster.CacheClusterId != nil {
+			cacheClusterIds = append(cacheClusterIds, *cacheCluster.CacheClusterId)
+		}
+	}
+	return cacheClusterIds
+}
+
+func cacheSubnetGroupNames(cacheSubnetGroups []types.CacheSubnetGroup) []string {
+	cacheSubnetGroupNames := make([]string, 0, len(cacheSubnetGroups))
+	for _, cacheSubnetGroup := range cacheSubnetGroups {
+		if cacheSubnetGroup.CacheSubnetGroupName != nil {
+			cacheSubnetGroupNames = append(cacheSubnetGroupNames, *cacheSubnetGroup.CacheSubnetGroupName)
+		}
+	}
+	return cacheSubnetGroupNames
+}
+
+// deleteCacheClusters deletes cacheClusters, deleting the whole replication
+// group of those that are members of one, and waits for them to be deleted. If
+// finalSnapshotPrefix is set then a final snapshot of each Redis replication
+// group and cache cluster is taken, named with the prefix followed by its ID.
+func deleteCacheClusters(ctx context.Context, client *elasticache.Client, cacheClusters []types.CacheCluster, finalSnapshotPrefix string) error {
+	var errs error
+	var deletingReplicationGroupIds []string
+	var deletingCacheClusterIds []string
+	seenReplicationGroupIds := newStringSet()
+	for _, cacheCluster := range cacheClusters {
+		if cacheCluster.CacheClusterId == nil {
+			continue
+		}
+
+		// Delete the replication group, if any.
+		if cacheCluster.ReplicationGroupId != nil {
+			if seenReplicationGroupIds.contains(*cacheCluster.ReplicationGroupId) {
+				continue
+			}
+			seenReplicationGroupIds[*cacheCluster.ReplicationGroupId] = struct{}{}
+			if aws.ToString(cacheCluster.CacheClusterStatus) == "deleting" {
+				deletingReplicationGroupIds = append(deletingReplicationGroupIds, *cacheCluster.ReplicationGroupId)
+				continue
+			}
+			input := elasticache.DeleteReplicationGroupInput{
+				ReplicationGroupId:   cacheCluster.ReplicationGroupId,
+				RetainPrimaryCluster: aws.Bool(false),
+			}
+			if finalSnapshotPrefix != "" {
+				input.FinalSnapshotIdentifier = aws.String(finalSnapshotPrefix + *cacheCluster.ReplicationGroupId)
+			}
+			_, err := client.DeleteReplicationGroup(ctx, &input)
+			log.Err(err).
+				Str("ReplicationGroupId", *cacheCluster.ReplicationGroupId).
+				Str("FinalSnapshotIdentifier", aws.ToString(input.FinalSnapshotIdentifier)).
+				Msg("DeleteReplicationGroup")
+			errs = multierr.Append(errs, err)
+			if err == nil {
+				deletingReplicationGroupIds = append(deletingReplicationGroupIds, *cacheCluster.ReplicationGroupId)
+			}
+			continue
+		}
+
+		// Otherwise delete the cache cluster.
+		if aws.ToString(cacheCluster.CacheClusterStatus) == "deleting" {
+			deletingCacheClusterIds = append(deletingCacheClusterIds, *cacheCluster.CacheClusterId)
+			continue
+		}
+		input := elasticache.DeleteCacheClusterInput{
+			CacheClusterId: cacheCluster.CacheClusterId,
+		}
+		if finalSnapshotPrefix != "" && aws.ToString(cacheCluster.Engine) == "redis" {
+			input.FinalSnapshotIdentifier = aws.String(finalSnapshotPrefix + *cacheCluster.CacheClusterId)
+		}
+		_, err := client.DeleteCacheCluster(ctx, &input)
+		log.Err(err).
+			Str("CacheClusterId", *cacheCluster.CacheClusterId).
+			Str("FinalSnapshotIdentifier", aws.ToString(input.FinalSnapshotIdentifier)).
+			Msg("DeleteCacheCluster")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingCacheClusterIds = append(deletingCacheClusterIds, *cacheCluster.CacheClusterId)
+		}
+	}
+
+	// Wait for the replication groups to be deleted.
+	replicationGroupDeletedWaiter := elasticache.NewReplicationGroupDeletedWaiter(client)
+	for _, replicationGroupId := range deletingReplicationGroupIds {
+		log.Info().
+			Str("ReplicationGroupId", replicationGroupId).
+			Msg("ReplicationGroupDeletedWaiter.Wait")
+		err := replicationGroupDeletedWaiter.Wait(ctx, &elasticache.DescribeReplicationGroupsInput{
+			ReplicationGroupId: aws.String(replicationGroupId),
+		}, cacheClusterDeletedWaiterMaxDuration)
+		log.Err(err).
+			Str("ReplicationGroupId", replicationGroupId).
+			Msg("ReplicationGroupDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	// Wait for the cache clusters to be deleted.
+	cacheClusterDeletedWaiter := elasticache.NewCacheClusterDeletedWaiter(client)
+	for _, cacheClusterId := range deletingCacheClusterIds {
+		log.Info().
+			Str("CacheClusterId", cacheClusterId).
+			Msg("CacheClusterDeletedWaiter.Wait")
+		err := cacheClusterDeletedWaiter.Wait(ctx, &elasticache.DescribeCacheClustersInput{
+			CacheClusterId: aws.String(cacheClusterId),
+		}, cacheClusterDeletedWaiterMaxDuration)
+		log.Err(err).
+			Str("CacheClusterId", cacheClusterId).
+			Msg("CacheClusterDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return errs
+}
+
+// deleteCacheSubnetGroups deletes cacheSubnetGroups, except for the default
+// cache subnet group, which cannot be deleted.
+func deleteCacheSubnetGroups(ctx context.Context, client *elasticache.Client, cacheSubnetGroups []types.CacheSubnetGroup) (errs error) {
+	for _, cacheSubnetGroup := range cacheSubnetGroups {
+		if cacheSubnetGroup.CacheSubnetGroupName == nil || *cacheSubnetGroup.CacheSubnetGroupName == "default" {
+			continue
+		}
+		_, err := client.DeleteCacheSubnetGroup(ctx, &elasticache.DeleteCacheSubnetGroupInput{
+			CacheSubnetGroupName: cacheSubnetGroup.CacheSubnetGroupName,
+		})
+		log.Err(err).
+			Str("CacheSubnetGroupName", *cacheSubnetGroup.CacheSubnetGroupName).
+			Msg("DeleteCacheSubnetGroup")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteElastiCacheClustersInVpc deletes the ElastiCache replication groups
+// and cache clusters in the VPC vpcId, waits for them to be deleted, and then
+// deletes the VPC's cache subnet groups.
+func deleteElastiCacheClustersInVpc(ctx context.Context, client *elasticache.Client, vpcId, finalSnapshotPrefix string) error {
+	cacheSubnetGroups, err := listCacheSubnetGroups(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listCacheSubnetGroups")
+		return err
+	}
+	log.Info().
+		Strs("cacheSubnetGroupNames", cacheSubnetGroupNames(cacheSubnetGroups)).
+		Msg("listCacheSubnetGroups")
+	if len(cacheSubnetGroups) == 0 {
+		return nil
+	}
+
+	cacheClusters, err := listCacheClusters(ctx, client, newStringSet(cacheSubnetGroupNames(cacheSubnetGroups)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listCacheClusters")
+		return err
+	}
+	log.Info().
+		Strs("cacheClusterIds", cacheClusterIds(cacheClusters)).
+		Msg("listCacheClusters")
+	if len(cacheClusters) > 0 {
+		err := deleteCacheClusters(ctx, client, cacheClusters, finalSnapshotPrefix)
+		log.Err(err).
+			Strs("cacheClusterIds", cacheClusterIds(cacheClusters)).
+			Msg("deleteCacheClusters")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteCacheSubnetGroups(ctx, client, cacheSubnetGroups)
+	log.Err(err).
+		Strs("cacheSubnetGroupNames", cacheSubnetGroupNames(cacheSubnetGroups)).
+		Msg("deleteCacheSubnetGroups")
+	return err
+}
+
+// listCacheClusters returns the cache clusters whose cache subnet group is one
+// of cacheSubnetGroupNames.
+func listCacheClusters(ctx context.Context, client *elasticache.Client, cacheSubnetGroupNames stringSet) ([]types.CacheCluster, error) {
+	input := elasticache.DescribeCacheClustersInput{}
+	var cacheClusters []types.CacheCluster
+	for {
+		output, err := client.DescribeCacheClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, cacheCluster := range output.CacheClusters {
+			if cacheCluster.CacheSubnetGroupName != nil && cacheSubnetGroupNames.contains(*cacheCluster.CacheSubnetGroupName) {
+				cacheClusters = append(cacheClusters, cacheCluster)
+			}
+		}
+		if output.Marker == nil {
+			return cacheClusters, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// listCacheSubnetGroups returns the cache subnet groups in the VPC vpcId.
+func listCacheSubnetGroups(ctx context.Context, client *elasticache.Client, vpcId string) ([]types.CacheSubnetGroup, error) {
+	input := elasticache.DescribeCacheSubnetGroupsInput{}
+	var cacheSubnetGroups []types.CacheSubnetGroup
+	for {
+		output, err := client.DescribeCacheSubnetGroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, cacheSubnetGroup := range output.CacheSubnetGroups {
+			if aws.ToString(cacheSubnetGroup.VpcId) == vpcId {
+				cacheSubnetGroups = append(cacheSubnetGroups, cacheSubnetGroup)
+			}
+		}
+		if output.Marker == nil {
+			return cacheSubnetGroups, nil
+		}
+		input.Marker = output.Marker
+	}
+}
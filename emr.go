@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/emr"
+	"github.com/aws/aws-sdk-go-v2/service/emr/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func emrClusterIds(clusters []types.Cluster) []string {
+	clusterIds := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.Id != nil {
+			clusterIds = append(clusterIds, *cluster.Id)
+		}
+	}
+	return clusterIds
+}
+
+// emrClusterTerminatedRetryable is the Retryable function of the EMR
+// ClusterTerminatedWaiter. Unlike the SDK's default, it accepts clusters that
+// terminated with errors, as they are terminated nonetheless.
+func emrClusterTerminatedRetryable(ctx context.Context, input *emr.DescribeClusterInput, output *emr.DescribeClusterOutput, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	if output.Cluster == nil || output.Cluster.Status == nil {
+		return true, nil
+	}
+	switch output.Cluster.Status.State {
+	case types.ClusterStateTerminated, types.ClusterStateTerminatedWithErrors:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// listEmrClusters returns the EMR clusters that have not terminated with an
+// instance subnet in subnetIds.
+func listEmrClusters(ctx context.Context, client *emr.Client, subnetIds stringSet) ([]types.Cluster, error) {
+	input := emr.ListClustersInput{
+		ClusterStates: []types.ClusterState{
+			types.ClusterStateStarting,
+			types.ClusterStateBootstrapping,
+			types.ClusterStateRunning,
+			types.ClusterStateWaiting,
+			types.ClusterStateTerminating,
+		},
+	}
+	var clusters []types.Cluster
+	for {
+		output, err := client.ListClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	CLUSTER:
+		for _, clusterSummary := range output.Clusters {
+			describeClusterOutput, err := client.DescribeCluster(ctx, &emr.DescribeClusterInput{
+				ClusterId: clusterSummary.Id,
+			})
+			if err != nil {
+				return nil, err
+			}
+			cluster := describeClusterOutput.Cluster
+			if cluster == nil || cluster.Ec2InstanceAttributes == nil {
+				continue
+			}
+			clusterSubnetIds := cluster.Ec2InstanceAttributes.RequestedEc2SubnetIds
+			if cluster.Ec2InstanceAttributes.Ec2SubnetId != nil {
+				clusterSubnetIds = append(clusterSubnetIds, *cluster.Ec2InstanceAttributes.Ec2SubnetId)
+			}
+			for _, subnetId := range clusterSubnetIds {
+				if subnetIds.contains(subnetId) {
+					clusters = append(clusters, *cluster)
+					continue CLUSTER
+				}
+			}
+		}
+		if output.Marker == nil {
+			return clusters, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// terminateEmrClusters terminates clusters and waits for them to terminate.
+// Termination-protected clusters are only terminated if force is true, in
+// which case their protection is disabled first.
+func terminateEmrClusters(ctx context.Context, client *emr.Client, clusters []types.Cluster, force bool) (errs error) {
+	var terminatingClusterIds []string
+	for _, cluster := range clusters {
+		if cluster.Id == nil {
+			continue
+		}
+		if cluster.Status != nil && cluster.Status.State == types.ClusterStateTerminating {
+			terminatingClusterIds = append(terminatingClusterIds, *cluster.Id)
+			continue
+		}
+
+		// Disable termination protection.
+		if cluster.TerminationProtected {
+			if !force {
+				errs = multierr.Append(errs, fmt.Errorf("%s: termination protection enabled", *cluster.Id))
+				continue
+			}
+			_, err := client.SetTerminationProtection(ctx, &emr.SetTerminationProtectionInput{
+				JobFlowIds:           []string{*cluster.Id},
+				TerminationProtected: false,
+			})
+			log.Err(err).
+				Str("ClusterId", *cluster.Id).
+				Msg("SetTerminationProtection")
+			if err != nil {
+				errs = multierr.Append(errs, err)
+				continue
+			}
+		}
+
+		_, err := client.TerminateJobFlows(ctx, &emr.TerminateJobFlowsInput{
+			JobFlowIds: []string{*cluster.Id},
+		})
+		log.Err(err).
+			Str("ClusterId", *cluster.Id).
+			Msg("TerminateJobFlows")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			terminatingClusterIds = append(terminatingClusterIds, *cluster.Id)
+		}
+	}
+
+	// Wait for the clusters to terminate.
+	clusterTerminatedWaiter := emr.NewClusterTerminatedWaiter(client, func(options *emr.ClusterTerminatedWaiterOptions) {
+		options.Retryable = emrClusterTerminatedRetryable
+	})
+	for _, clusterId := range terminatingClusterIds {
+		log.Info().
+			Str("ClusterId", clusterId).
+			Msg("ClusterTerminatedWaiter.Wait")
+		err := clusterTerminatedWaiter.Wait(ctx, &emr.DescribeClusterInput{
+			ClusterId: aws.String(clusterId),
+		}, emrClusterTerminatedWaiterMaxDuration)
+		log.Err(err).
+			Str("ClusterId", clusterId).
+			Msg("ClusterTerminatedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
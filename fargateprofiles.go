@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/rs/zerolog/log"
+)
+
+// deleteClusterFargateProfiles deletes all of cluster's FargateProfiles. Only
+// one FargateProfile per cluster can be deleting at a time, so each
+// FargateProfile is deleted and waited for in turn.
+func deleteClusterFargateProfiles(ctx context.Context, client *eks.Client, cluster *types.Cluster) error {
+	fargateProfiles, err := listClusterFargateProfiles(ctx, client, cluster)
+	if err != nil {
+		return err
+	}
+	for _, profile := range fargateProfiles {
+		_, err := client.DeleteFargateProfile(ctx, &eks.DeleteFargateProfileInput{
+			ClusterName:        cluster.Name,
+			FargateProfileName: &profile,
+		})
+		log.Err(err).
+			Str("ClusterName", *cluster.Name).
+			Str("FargateProfileName", profile).
+			Msg("DeleteFargateProfile")
+		// Ignore ResourceInUseExceptions in case the FargateProfile is already
+		// being deleted.
+		var resourceInUseExceptionErr *types.ResourceInUseException
+		if err != nil && !errors.As(err, &resourceInUseExceptionErr) {
+			return err
+		}
+
+		fargateProfileDeletedWaiter := eks.NewFargateProfileDeletedWaiter(client)
+		log.Info().
+			Str("ClusterName", *cluster.Name).
+			Str("FargateProfileName", profile).
+			Msg("FargateProfileDeletedWaiter.Wait")
+		err = fargateProfileDeletedWaiter.Wait(ctx, &eks.DescribeFargateProfileInput{
+			ClusterName:        cluster.Name,
+			FargateProfileName: &profile,
+		}, fargateProfileDeletedWaiterMaxDuration)
+		log.Err(err).
+			Msg("FargateProfileDeletedWaiter.Wait")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listClusterFargateProfiles(ctx context.Context, client *eks.Client, cluster *types.Cluster) ([]string, error) {
+	input := eks.ListFargateProfilesInput{
+		ClusterName: cluster.Name,
+	}
+	var fargateProfileNames []string
+	for {
+		output, err := client.ListFargateProfiles(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		fargateProfileNames = append(fargateProfileNames, output.FargateProfileNames...)
+		if output.NextToken == nil {
+			return fargateProfileNames, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func activeInstanceIds(activeInstances []types.ActiveInstance) []string {
+	instanceIds := make([]string, 0, len(activeInstances))
+	for _, activeInstance := range activeInstances {
+		if activeInstance.InstanceId != nil {
+			instanceIds = append(instanceIds, *activeInstance.InstanceId)
+		}
+	}
+	return instanceIds
+}
+
+// cancelSpotFleetRequests cancels spotFleetRequests and terminates their
+// Instances.
+func cancelSpotFleetRequests(ctx context.Context, client *ec2.Client, spotFleetRequests []types.SpotFleetRequestConfig) error {
+	ids := spotFleetRequestIds(spotFleetRequests)
+	output, err := client.CancelSpotFleetRequests(ctx, &ec2.CancelSpotFleetRequestsInput{
+		SpotFleetRequestIds: ids,
+		TerminateInstances:  aws.Bool(true),
+	})
+	log.Err(err).
+		Strs("SpotFleetRequestIds", ids).
+		Msg("CancelSpotFleetRequests")
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, unsuccessfulFleetRequest := range output.UnsuccessfulFleetRequests {
+		if unsuccessfulFleetRequest.Error == nil {
+			continue
+		}
+		errs = multierr.Append(errs, fmt.Errorf("%s: %s: %s", aws.ToString(unsuccessfulFleetRequest.SpotFleetRequestId), unsuccessfulFleetRequest.Error.Code, aws.ToString(unsuccessfulFleetRequest.Error.Message)))
+	}
+	return errs
+}
+
+// cancelSpotInstanceRequests cancels spotInstanceRequests so that they do not
+// launch replacement Instances. Instances that they have already launched are
+// not terminated.
+func cancelSpotInstanceRequests(ctx context.Context, client *ec2.Client, spotInstanceRequests []types.SpotInstanceRequest) error {
+	ids := spotInstanceRequestIds(spotInstanceRequests)
+	_, err := client.CancelSpotInstanceRequests(ctx, &ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: ids,
+	})
+	log.Err(err).
+		Strs("SpotInstanceRequestIds", ids).
+		Msg("CancelSpotInstanceRequests")
+	return err
+}
+
+// deleteFleetsInSubnets cancels the Spot Instance requests and Spot Fleet
+// requests, and deletes the EC2 fleets, that launch Instances into any of
+// subnetIds, so that they do not replace Instances as they are terminated. It
+// accumulates errors.
+func deleteFleetsInSubnets(ctx context.Context, client *ec2.Client, subnetIds stringSet) (errs error) {
+	if spotInstanceRequests, err := listSpotInstanceRequests(ctx, client, subnetIds); err != nil {
+		log.Err(err).
+			Msg("listSpotInstanceRequests")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("spotInstanceRequestIds", spotInstanceRequestIds(spotInstanceRequests)).
+			Msg("listSpotInstanceRequests")
+		if len(spotInstanceRequests) > 0 {
+			err := cancelSpotInstanceRequests(ctx, client, spotInstanceRequests)
+			log.Err(err).
+				Strs("spotInstanceRequestIds", spotInstanceRequestIds(spotInstanceRequests)).
+				Msg("cancelSpotInstanceRequests")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if spotFleetRequests, err := listSpotFleetRequests(ctx, client, subnetIds); err != nil {
+		log.Err(err).
+			Msg("listSpotFleetRequests")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("spotFleetRequestIds", spotFleetRequestIds(spotFleetRequests)).
+			Msg("listSpotFleetRequests")
+		if len(spotFleetRequests) > 0 {
+			err := cancelSpotFleetRequests(ctx, client, spotFleetRequests)
+			log.Err(err).
+				Strs("spotFleetRequestIds", spotFleetRequestIds(spotFleetRequests)).
+				Msg("cancelSpotFleetRequests")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if fleets, err := listFleets(ctx, client, subnetIds); err != nil {
+		log.Err(err).
+			Msg("listFleets")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("fleetIds", fleetIds(fleets)).
+			Msg("listFleets")
+		if len(fleets) > 0 {
+			err := deleteFleets(ctx, client, fleets)
+			log.Err(err).
+				Strs("fleetIds", fleetIds(fleets)).
+				Msg("deleteFleets")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	return
+}
+
+// deleteFleets deletes the EC2 fleets and terminates their Instances.
+func deleteFleets(ctx context.Context, client *ec2.Client, fleets []types.FleetData) error {
+	ids := fleetIds(fleets)
+	output, err := client.DeleteFleets(ctx, &ec2.DeleteFleetsInput{
+		FleetIds:           ids,
+		TerminateInstances: aws.Bool(true),
+	})
+	log.Err(err).
+		Strs("FleetIds", ids).
+		Msg("DeleteFleets")
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, unsuccessfulFleetDeletion := range output.UnsuccessfulFleetDeletions {
+		if unsuccessfulFleetDeletion.Error == nil {
+			continue
+		}
+		errs = multierr.Append(errs, fmt.Errorf("%s: %s: %s", aws.ToString(unsuccessfulFleetDeletion.FleetId), unsuccessfulFleetDeletion.Error.Code, aws.ToString(unsuccessfulFleetDeletion.Error.Message)))
+	}
+	return errs
+}
+
+func fleetIds(fleets []types.FleetData) []string {
+	fleetIds := make([]string, 0, len(fleets))
+	for _, fleet := range fleets {
+		if fleet.FleetId != nil {
+			fleetIds = append(fleetIds, *fleet.FleetId)
+		}
+	}
+	return fleetIds
+}
+
+// launchTemplateSubnetIds returns the subnet IDs of the network interfaces in
+// the launch template version specified by launchTemplateSpecification, or nil
+// if the launch template does not exist. Results are cached in cache.
+func launchTemplateSubnetIds(ctx context.Context, client *ec2.Client, launchTemplateSpecification *types.FleetLaunchTemplateSpecification, cache map[string][]string) ([]string, error) {
+	if launchTemplateSpecification == nil {
+		return nil, nil
+	}
+	input := ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId:   launchTemplateSpecification.LaunchTemplateId,
+		LaunchTemplateName: launchTemplateSpecification.LaunchTemplateName,
+		Versions:           []string{"$Default"},
+	}
+	if launchTemplateSpecification.Version != nil {
+		input.Versions = []string{*launchTemplateSpecification.Version}
+	}
+	key := aws.ToString(input.LaunchTemplateId) + "/" + aws.ToString(input.LaunchTemplateName) + "/" + input.Versions[0]
+	if subnetIds, ok := cache[key]; ok {
+		return subnetIds, nil
+	}
+
+	output, err := client.DescribeLaunchTemplateVersions(ctx, &input)
+	if err != nil {
+		if apiError := smithy.APIError(nil); errors.As(err, &apiError) && strings.HasPrefix(apiError.ErrorCode(), "InvalidLaunchTemplate") {
+			cache[key] = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+	var subnetIds []string
+	for _, launchTemplateVersion := range output.LaunchTemplateVersions {
+		if launchTemplateVersion.LaunchTemplateData == nil {
+			continue
+		}
+		for _, networkInterface := range launchTemplateVersion.LaunchTemplateData.NetworkInterfaces {
+			if networkInterface.SubnetId != nil {
+				subnetIds = append(subnetIds, *networkInterface.SubnetId)
+			}
+		}
+	}
+	cache[key] = subnetIds
+	return subnetIds, nil
+}
+
+// listFleetInstanceIds returns the IDs of the active Instances of the EC2 fleet
+// fleetId.
+func listFleetInstanceIds(ctx context.Context, client *ec2.Client, fleetId string) ([]string, error) {
+	input := ec2.DescribeFleetInstancesInput{
+		FleetId: aws.String(fleetId),
+	}
+	var instanceIds []string
+	for {
+		output, err := client.DescribeFleetInstances(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		instanceIds = append(instanceIds, activeInstanceIds(output.ActiveInstances)...)
+		if output.NextToken == nil {
+			return instanceIds, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listFleets returns the active EC2 fleets that launch Instances into one of
+// subnetIds, or all active EC2 fleets if subnetIds is nil. A fleet's subnets
+// are taken from its launch template overrides or, if they do not specify any,
+// from its launch template versions. If neither specifies any subnets, then the
+// subnets of the fleet's Instances are used.
+func listFleets(ctx context.Context, client *ec2.Client, subnetIds stringSet) ([]types.FleetData, error) {
+	input := ec2.DescribeFleetsInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("fleet-state"),
+				Values: []string{
+					string(types.FleetStateCodeSubmitted),
+					string(types.FleetStateCodeActive),
+					string(types.FleetStateCodeModifying),
+				},
+			},
+		},
+	}
+	launchTemplateSubnetIdsCache := make(map[string][]string)
+	var fleets []types.FleetData
+	for {
+		output, err := client.DescribeFleets(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, fleet := range output.Fleets {
+			if fleet.FleetId == nil {
+				continue
+			}
+			if subnetIds == nil {
+				fleets = append(fleets, fleet)
+				continue
+			}
+			var fleetSubnetIds []string
+			for _, launchTemplateConfig := range fleet.LaunchTemplateConfigs {
+				var overrideSubnetIds []string
+				for _, override := range launchTemplateConfig.Overrides {
+					overrideSubnetIds = append(overrideSubnetIds, splitSubnetIds(override.SubnetId)...)
+				}
+				if len(overrideSubnetIds) == 0 {
+					overrideSubnetIds, err = launchTemplateSubnetIds(ctx, client, launchTemplateConfig.LaunchTemplateSpecification, launchTemplateSubnetIdsCache)
+					if err != nil {
+						return nil, err
+					}
+				}
+				fleetSubnetIds = append(fleetSubnetIds, overrideSubnetIds...)
+			}
+			if len(fleetSubnetIds) == 0 {
+				instanceIds, err := listFleetInstanceIds(ctx, client, *fleet.FleetId)
+				if err != nil {
+					return nil, err
+				}
+				fleetSubnetIds, err = listInstanceSubnetIds(ctx, client, instanceIds)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, subnetId := range fleetSubnetIds {
+				if subnetIds.contains(subnetId) {
+					fleets = append(fleets, fleet)
+					break
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return fleets, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listInstanceSubnetIds returns the subnet IDs of the Instances instanceIds.
+func listInstanceSubnetIds(ctx context.Context, client *ec2.Client, instanceIds []string) ([]string, error) {
+	if len(instanceIds) == 0 {
+		return nil, nil
+	}
+	reservations, err := listReservations(ctx, client, []types.Filter{
+		{
+			Name:   aws.String("instance-id"),
+			Values: instanceIds,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var subnetIds []string
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			if instance.SubnetId != nil {
+				subnetIds = append(subnetIds, *instance.SubnetId)
+			}
+		}
+	}
+	return subnetIds, nil
+}
+
+// listSpotFleetInstanceIds returns the IDs of the active Instances of the Spot
+// Fleet request spotFleetRequestId.
+func listSpotFleetInstanceIds(ctx context.Context, client *ec2.Client, spotFleetRequestId string) ([]string, error) {
+	input := ec2.DescribeSpotFleetInstancesInput{
+		SpotFleetRequestId: aws.String(spotFleetRequestId),
+	}
+	var instanceIds []string
+	for {
+		output, err := client.DescribeSpotFleetInstances(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		instanceIds = append(instanceIds, activeInstanceIds(output.ActiveInstances)...)
+		if output.NextToken == nil {
+			return instanceIds, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSpotFleetRequests returns the active Spot Fleet requests that launch
+// Instances into one of subnetIds, or all active Spot Fleet requests if
+// subnetIds is nil. A request's subnets are taken from its
+// launch specifications and launch template overrides or, if they do not
+// specify any, from its launch template versions. If none of these specify any
+// subnets, then the subnets of the request's Instances are used.
+func listSpotFleetRequests(ctx context.Context, client *ec2.Client, subnetIds stringSet) ([]types.SpotFleetRequestConfig, error) {
+	input := ec2.DescribeSpotFleetRequestsInput{}
+	launchTemplateSubnetIdsCache := make(map[string][]string)
+	var spotFleetRequests []types.SpotFleetRequestConfig
+	for {
+		output, err := client.DescribeSpotFleetRequests(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, spotFleetRequest := range output.SpotFleetRequestConfigs {
+			switch spotFleetRequest.SpotFleetRequestState {
+			case types.BatchStateSubmitted, types.BatchStateActive, types.BatchStateModifying:
+			default:
+				continue
+			}
+			if subnetIds == nil {
+				spotFleetRequests = append(spotFleetRequests, spotFleetRequest)
+				continue
+			}
+			if spotFleetRequest.SpotFleetRequestConfig == nil {
+				continue
+			}
+			var requestSubnetIds []string
+			for _, launchSpecification := range spotFleetRequest.SpotFleetRequestConfig.LaunchSpecifications {
+				requestSubnetIds = append(requestSubnetIds, splitSubnetIds(launchSpecification.SubnetId)...)
+				for _, networkInterface := range launchSpecification.NetworkInterfaces {
+					requestSubnetIds = append(requestSubnetIds, splitSubnetIds(networkInterface.SubnetId)...)
+				}
+			}
+			for _, launchTemplateConfig := range spotFleetRequest.SpotFleetRequestConfig.LaunchTemplateConfigs {
+				var overrideSubnetIds []string
+				for _, override := range launchTemplateConfig.Overrides {
+					overrideSubnetIds = append(overrideSubnetIds, splitSubnetIds(override.SubnetId)...)
+				}
+				if len(overrideSubnetIds) == 0 {
+					overrideSubnetIds, err = launchTemplateSubnetIds(ctx, client, launchTemplateConfig.LaunchTemplateSpecification, launchTemplateSubnetIdsCache)
+					if err != nil {
+						return nil, err
+					}
+				}
+				requestSubnetIds = append(requestSubnetIds, overrideSubnetIds...)
+			}
+			if len(requestSubnetIds) == 0 && spotFleetRequest.SpotFleetRequestId != nil {
+				instanceIds, err := listSpotFleetInstanceIds(ctx, client, *spotFleetRequest.SpotFleetRequestId)
+				if err != nil {
+					return nil, err
+				}
+				requestSubnetIds, err = listInstanceSubnetIds(ctx, client, instanceIds)
+				if err != nil {
+					return nil, err
+				}
+			}
+			for _, subnetId := range requestSubnetIds {
+				if subnetIds.contains(subnetId) {
+					spotFleetRequests = append(spotFleetRequests, spotFleetRequest)
+					break
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return spotFleetRequests, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSpotInstanceRequests returns the open and active Spot Instance requests
+// whose launch specification is in one of subnetIds.
+func listSpotInstanceRequests(ctx context.Context, client *ec2.Client, subnetIds stringSet) ([]types.SpotInstanceRequest, error) {
+	input := ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []types.Filter{
+			{
+				Name: aws.String("state"),
+				Values: []string{
+					string(types.SpotInstanceStateOpen),
+					string(types.SpotInstanceStateActive),
+				},
+			},
+		},
+	}
+	var spotInstanceRequests []types.SpotInstanceRequest
+	for {
+		output, err := client.DescribeSpotInstanceRequests(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, spotInstanceRequest := range output.SpotInstanceRequests {
+			launchSpecification := spotInstanceRequest.LaunchSpecification
+			if launchSpecification == nil {
+				continue
+			}
+			requestSubnetIds := splitSubnetIds(launchSpecification.SubnetId)
+			for _, networkInterface := range launchSpecification.NetworkInterfaces {
+				requestSubnetIds = append(requestSubnetIds, splitSubnetIds(networkInterface.SubnetId)...)
+			}
+			for _, subnetId := range requestSubnetIds {
+				if subnetIds.contains(subnetId) {
+					spotInstanceRequests = append(spotInstanceRequests, spotInstanceRequest)
+					break
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return spotInstanceRequests, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func spotFleetRequestIds(spotFleetRequests []types.SpotFleetRequestConfig) []string {
+	spotFleetRequestIds := make([]string, 0, len(spotFleetRequests))
+	for _, spotFleetRequest := range spotFleetRequests {
+		if spotFleetRequest.SpotFleetRequestId != nil {
+			spotFleetRequestIds = append(spotFleetRequestIds, *spotFleetRequest.SpotFleetRequestId)
+		}
+	}
+	return spotFleetRequestIds
+}
+
+func spotInstanceRequestIds(spotInstanceRequests []types.SpotInstanceRequest) []string {
+	spotInstanceRequestIds := make([]string, 0, len(spotInstanceRequests))
+	for _, spotInstanceRequest := range spotInstanceRequests {
+		if spotInstanceRequest.SpotInstanceRequestId != nil {
+			spotInstanceRequestIds = append(spotInstanceRequestIds, *spotInstanceRequest.SpotInstanceRequestId)
+		}
+	}
+	return spotInstanceRequestIds
+}
+
+// splitSubnetIds splits a comma-separated list of subnet IDs, as used in Spot
+// Fleet launch specifications.
+func splitSubnetIds(subnetIds *string) []string {
+	if subnetIds == nil {
+		return nil
+	}
+	var result []string
+	for _, subnetId := range strings.Split(*subnetIds, ",") {
+		if subnetId = strings.TrimSpace(subnetId); subnetId != "" {
+			result = append(result, subnetId)
+		}
+	}
+	return result
+}
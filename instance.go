@@ -2,10 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
 )
 
 func instanceIds(reservations []types.Reservation) []string {
@@ -20,9 +26,9 @@ func instanceIds(reservations []types.Reservation) []string {
 	return instanceIds
 }
 
-func listReservations(ctx context.Context, client *ec2.Client, vpcId string) ([]types.Reservation, error) {
+func listReservations(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.Reservation, error) {
 	input := ec2.DescribeInstancesInput{
-		Filters: ec2VpcFilter(vpcId),
+		Filters: filters,
 	}
 	var reservations []types.Reservation
 	for {
@@ -38,8 +44,9 @@ func listReservations(ctx context.Context, client *ec2.Client, vpcId string) ([]
 	}
 }
 
-func terminateInstancesInReservations(ctx context.Context, client *ec2.Client, reservations []types.Reservation) error {
-	// Find all non-terminated Instances.
+// nonTerminatedInstanceIds returns the IDs of all Instances in reservations
+// that are not terminated.
+func nonTerminatedInstanceIds(reservations []types.Reservation) []string {
 	var nonTerminatedInstanceIds []string
 	for _, reservation := range reservations {
 		for _, instance := range reservation.Instances {
@@ -52,21 +59,128 @@ func terminateInstancesInReservations(ctx context.Context, client *ec2.Client, r
 			nonTerminatedInstanceIds = append(nonTerminatedInstanceIds, *instance.InstanceId)
 		}
 	}
+	return nonTerminatedInstanceIds
+}
 
-	// If all Instances are terminated then we are done.
-	if len(nonTerminatedInstanceIds) == 0 {
-		return nil
+// disableInstanceProtection disables the termination and stop protection of
+// the Instance with ID instanceId.
+func disableInstanceProtection(ctx context.Context, client *ec2.Client, instanceId string) error {
+	_, err := client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceId),
+		DisableApiTermination: &types.AttributeBooleanValue{
+			Value: aws.Bool(false),
+		},
+	})
+	log.Err(err).
+		Str("InstanceId", instanceId).
+		Msg("ModifyInstanceAttribute DisableApiTermination")
+	if err != nil {
+		return err
 	}
 
-	// Terminate all non-terminated Instances.
-	terminateInstancesOutput, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
-		InstanceIds: nonTerminatedInstanceIds,
+	_, err = client.ModifyInstanceAttribute(ctx, &ec2.ModifyInstanceAttributeInput{
+		InstanceId: aws.String(instanceId),
+		DisableApiStop: &types.AttributeBooleanValue{
+			Value: aws.Bool(false),
+		},
 	})
 	log.Err(err).
-		Strs("InstanceIds", nonTerminatedInstanceIds).
+		Str("InstanceId", instanceId).
+		Msg("ModifyInstanceAttribute DisableApiStop")
+	return err
+}
+
+// excludeTerminationProtectedInstances returns the Instances in instanceIds
+// that can be terminated. If force is true then the protection of
+// termination-protected Instances is disabled, otherwise they are excluded and
+// reported as errors.
+func excludeTerminationProtectedInstances(ctx context.Context, client *ec2.Client, instanceIds []string, force bool) (terminatableInstanceIds []string, errs error) {
+	var protectedInstanceIds []string
+	for _, instanceId := range instanceIds {
+		protected, err := isInstanceTerminationProtected(ctx, client, instanceId)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		if protected && force {
+			if err := disableInstanceProtection(ctx, client, instanceId); err != nil {
+				errs = multierr.Append(errs, err)
+				continue
+			}
+			protected = false
+		}
+		if protected {
+			protectedInstanceIds = append(protectedInstanceIds, instanceId)
+			continue
+		}
+		terminatableInstanceIds = append(terminatableInstanceIds, instanceId)
+	}
+	if len(protectedInstanceIds) > 0 {
+		log.Warn().
+			Strs("InstanceIds", protectedInstanceIds).
+			Msg("skipping termination-protected Instances")
+		errs = multierr.Append(errs, fmt.Errorf("termination-protected instances: %s", strings.Join(protectedInstanceIds, ", ")))
+	}
+	return
+}
+
+// isInstanceTerminationProtected returns whether the Instance with ID
+// instanceId has termination protection enabled.
+func isInstanceTerminationProtected(ctx context.Context, client *ec2.Client, instanceId string) (bool, error) {
+	output, err := client.DescribeInstanceAttribute(ctx, &ec2.DescribeInstanceAttributeInput{
+		Attribute:  types.InstanceAttributeNameDisableApiTermination,
+		InstanceId: aws.String(instanceId),
+	})
+	if err != nil {
+		return false, err
+	}
+	return output.DisableApiTermination != nil && aws.ToBool(output.DisableApiTermination.Value), nil
+}
+
+// terminateInstances terminates the Instances with IDs instanceIds.
+func terminateInstances(ctx context.Context, client *ec2.Client, instanceIds []string) (*ec2.TerminateInstancesOutput, error) {
+	output, err := client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+		InstanceIds: instanceIds,
+	})
+	log.Err(err).
+		Strs("InstanceIds", instanceIds).
 		Msg("TerminateInstances")
+	return output, err
+}
+
+// terminateInstancesInReservations terminates all Instances in reservations
+// and waits for them to terminate. A single termination-protected Instance
+// causes TerminateInstances to fail for all Instances, so protected Instances
+// are handled separately: if force is true then their protection is disabled,
+// otherwise they are not terminated and are reported as errors.
+func terminateInstancesInReservations(ctx context.Context, client *ec2.Client, reservations []types.Reservation, force bool) error {
+	// Find all non-terminated Instances.
+	candidateInstanceIds := nonTerminatedInstanceIds(reservations)
+
+	// If all Instances are terminated then we are done.
+	if len(candidateInstanceIds) == 0 {
+		return nil
+	}
+
+	// Try to terminate all non-terminated Instances at once.
+	// DescribeInstances does not report termination protection, so each
+	// Instance's protection is only checked if this fails because at least
+	// one of them is protected.
+	var errs error
+	terminateInstancesOutput, err := terminateInstances(ctx, client, candidateInstanceIds)
+	if apiError := smithy.APIError(nil); errors.As(err, &apiError) && apiError.ErrorCode() == "OperationNotPermitted" {
+		var terminatableInstanceIds []string
+		terminatableInstanceIds, errs = excludeTerminationProtectedInstances(ctx, client, candidateInstanceIds, force)
+
+		// If there are no Instances that can be terminated then we are done.
+		if len(terminatableInstanceIds) == 0 {
+			return errs
+		}
+
+		terminateInstancesOutput, err = terminateInstances(ctx, client, terminatableInstanceIds)
+	}
 	if err != nil {
-		return err
+		return multierr.Append(errs, err)
 	}
 
 	// Find all terminating Instances.
@@ -83,7 +197,7 @@ func terminateInstancesInReservations(ctx context.Context, client *ec2.Client, r
 
 	// If there are no terminating Instances then we are done.
 	if len(terminatingInstanceIds) == 0 {
-		return nil
+		return errs
 	}
 
 	// Wait for all terminating Instances to terminate.
@@ -96,9 +210,5 @@ func terminateInstancesInReservations(ctx context.Context, client *ec2.Client, r
 	}, instanceTerminatedWaiterMaxDuration)
 	log.Err(err).
 		Msg("InstanceTerminatedWaiter.Wait")
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return multierr.Append(errs, err)
 }
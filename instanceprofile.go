@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteInstanceProfiles removes all Roles from each of instanceProfiles and
+// then deletes it.
+func deleteInstanceProfiles(ctx context.Context, client *iam.Client, instanceProfiles []types.InstanceProfile) (errs error) {
+	for _, instanceProfile := range instanceProfiles {
+		if instanceProfile.InstanceProfileName == nil {
+			continue
+		}
+
+		// Remove the Roles from the InstanceProfile.
+		var instanceProfileErrs error
+		for _, role := range instanceProfile.Roles {
+			if role.RoleName == nil {
+				continue
+			}
+			_, err := client.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+				InstanceProfileName: instanceProfile.InstanceProfileName,
+				RoleName:            role.RoleName,
+			})
+			log.Err(err).
+				Str("InstanceProfileName", *instanceProfile.InstanceProfileName).
+				Str("RoleName", *role.RoleName).
+				Msg("RemoveRoleFromInstanceProfile")
+			instanceProfileErrs = multierr.Append(instanceProfileErrs, err)
+		}
+		errs = multierr.Append(errs, instanceProfileErrs)
+		if instanceProfileErrs != nil {
+			continue
+		}
+
+		// Delete the InstanceProfile.
+		_, err := client.DeleteInstanceProfile(ctx, &iam.DeleteInstanceProfileInput{
+			InstanceProfileName: instanceProfile.InstanceProfileName,
+		})
+		log.Err(err).
+			Str("InstanceProfileName", *instanceProfile.InstanceProfileName).
+			Msg("DeleteInstanceProfile")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+func instanceProfileNames(instanceProfiles []types.InstanceProfile) []string {
+	instanceProfileNames := make([]string, 0, len(instanceProfiles))
+	for _, instanceProfile := range instanceProfiles {
+		if instanceProfile.InstanceProfileName != nil {
+			instanceProfileNames = append(instanceProfileNames, *instanceProfile.InstanceProfileName)
+		}
+	}
+	return instanceProfileNames
+}
+
+// listInstanceProfilesByTags returns the InstanceProfiles with paths starting
+// with pathPrefix that have at least one of tags, which is a map of tag keys to
+// values.
+func listInstanceProfilesByTags(ctx context.Context, client *iam.Client, pathPrefix string, tags map[string]string) ([]types.InstanceProfile, error) {
+	input := iam.ListInstanceProfilesInput{
+		PathPrefix: aws.String(pathPrefix),
+	}
+	var instanceProfiles []types.InstanceProfile
+	for {
+		output, err := client.ListInstanceProfiles(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, instanceProfile := range output.InstanceProfiles {
+			if instanceProfile.InstanceProfileName == nil {
+				continue
+			}
+
+			// ListInstanceProfiles does not always return tags, in which case
+			// they are retrieved with GetInstanceProfile.
+			instanceProfileTags := instanceProfile.Tags
+			if len(instanceProfileTags) == 0 {
+				getInstanceProfileOutput, err := client.GetInstanceProfile(ctx, &iam.GetInstanceProfileInput{
+					InstanceProfileName: instanceProfile.InstanceProfileName,
+				})
+				if err != nil {
+					var noSuchEntityException *types.NoSuchEntityException
+					if errors.As(err, &noSuchEntityException) {
+						continue
+					}
+					return nil, err
+				}
+				if getInstanceProfileOutput.InstanceProfile != nil {
+					instanceProfileTags = getInstanceProfileOutput.InstanceProfile.Tags
+				}
+			}
+
+			for _, tag := range instanceProfileTags {
+				if tag.Key == nil || tag.Value == nil {
+					continue
+				}
+				if value, ok := tags[*tag.Key]; ok && value == *tag.Value {
+					instanceProfiles = append(instanceProfiles, instanceProfile)
+					break
+				}
+			}
+		}
+		if !output.IsTruncated {
+			return instanceProfiles, nil
+		}
+		input.Marker = output.Marker
+	}
+}
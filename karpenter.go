@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+const (
+	// karpenterMaxTerminateRounds is the maximum number of times that
+	// Karpenter Instances are terminated while waiting for Karpenter to stop
+	// launching replacements.
+	karpenterMaxTerminateRounds = 3
+
+	// karpenterTerminateRoundInterval is the time to wait between rounds of
+	// terminating Karpenter Instances, to give Karpenter time to launch any
+	// replacements.
+	karpenterTerminateRoundInterval = 30 * time.Second
+)
+
+// deleteKarpenterResources terminates the Instances launched by Karpenter for
+// the cluster clusterName in the VPC with ID vpcId and deletes the
+// LaunchTemplates and InstanceProfiles that Karpenter created for them.
+// Karpenter relaunches Instances for as long as its controller is running, so
+// this should be called after the cluster's control plane is deleted. It
+// accumulates errors.
+func deleteKarpenterResources(ctx context.Context, clients *clients, clusterName, vpcId string, opts *options) (errs error) {
+	err := terminateKarpenterInstances(ctx, clients.ec2, clusterName, vpcId, opts.force)
+	log.Err(err).
+		Str("clusterName", clusterName).
+		Msg("terminateKarpenterInstances")
+	errs = multierr.Append(errs, err)
+
+	if launchTemplates, err := listLaunchTemplates(ctx, clients.ec2, []ec2types.Filter{
+		{
+			Name:   aws.String("tag:karpenter.k8s.aws/cluster"),
+			Values: []string{clusterName},
+		},
+	}); err != nil {
+		log.Err(err).
+			Msg("listLaunchTemplates")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("launchTemplateIds", launchTemplateIds(launchTemplates)).
+			Msg("listLaunchTemplates")
+		if len(launchTemplates) > 0 {
+			err := deleteLaunchTemplates(ctx, clients.ec2, launchTemplates)
+			log.Err(err).
+				Strs("launchTemplateIds", launchTemplateIds(launchTemplates)).
+				Msg("deleteLaunchTemplates")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if instanceProfiles, err := listInstanceProfilesByTags(ctx, clients.iam, karpenterInstanceProfilePathPrefix(clients.region, clusterName), map[string]string{
+		"karpenter.k8s.aws/cluster": clusterName,
+		"karpenter.sh/managed-by":   clusterName,
+	}); err != nil {
+		log.Err(err).
+			Msg("listInstanceProfilesByTags")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("instanceProfileNames", instanceProfileNames(instanceProfiles)).
+			Msg("listInstanceProfilesByTags")
+		if len(instanceProfiles) > 0 {
+			err := deleteInstanceProfiles(ctx, clients.iam, instanceProfiles)
+			log.Err(err).
+				Strs("instanceProfileNames", instanceProfileNames(instanceProfiles)).
+				Msg("deleteInstanceProfiles")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	return
+}
+
+// karpenterInstanceProfilePathPrefix returns the path prefix of the
+// InstanceProfiles that Karpenter creates for the cluster clusterName in region.
+func karpenterInstanceProfilePathPrefix(region, clusterName string) string {
+	return "/karpenter/" + region + "/" + clusterName + "/"
+}
+
+// listKarpenterReservations returns the Reservations containing Instances
+// launched by Karpenter for the cluster clusterName in the VPC with ID vpcId.
+func listKarpenterReservations(ctx context.Context, client *ec2.Client, clusterName, vpcId string) ([]ec2types.Reservation, error) {
+	clusterTagFilter := append(ec2VpcFilter(vpcId), ec2ClusterTagFilter(clusterName)...)
+	filterSets := [][]ec2types.Filter{
+		append([]ec2types.Filter{
+			{
+				Name:   aws.String("tag:karpenter.sh/discovery"),
+				Values: []string{clusterName},
+			},
+		}, ec2VpcFilter(vpcId)...),
+		append([]ec2types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"karpenter.sh/nodepool"},
+			},
+		}, clusterTagFilter...),
+		append([]ec2types.Filter{
+			{
+				Name:   aws.String("tag-key"),
+				Values: []string{"karpenter.sh/provisioner-name"},
+			},
+		}, clusterTagFilter...),
+	}
+
+	var reservations []ec2types.Reservation
+	seenReservationIds := newStringSet()
+	for _, filters := range filterSets {
+		filterReservations, err := listReservations(ctx, client, filters)
+		if err != nil {
+			return nil, err
+		}
+		for _, reservation := range filterReservations {
+			if reservation.ReservationId == nil || seenReservationIds.contains(*reservation.ReservationId) {
+				continue
+			}
+			seenReservationIds[*reservation.ReservationId] = struct{}{}
+			reservations = append(reservations, reservation)
+		}
+	}
+	return reservations, nil
+}
+
+// terminateKarpenterInstances terminates the Instances launched by Karpenter
+// for the cluster clusterName in the VPC with ID vpcId, repeating until
+// Karpenter stops launching replacements or karpenterMaxTerminateRounds is
+// reached.
+func terminateKarpenterInstances(ctx context.Context, client *ec2.Client, clusterName, vpcId string, force bool) error {
+	for round := 0; ; round++ {
+		if round != 0 {
+			log.Info().
+				Dur("duration", karpenterTerminateRoundInterval).
+				Msg("Sleep")
+			time.Sleep(karpenterTerminateRoundInterval)
+		}
+
+		reservations, err := listKarpenterReservations(ctx, client, clusterName, vpcId)
+		if err != nil {
+			return err
+		}
+		karpenterInstanceIds := nonTerminatedInstanceIds(reservations)
+		log.Info().
+			Int("round", round).
+			Strs("instanceIds", karpenterInstanceIds).
+			Msg("listKarpenterReservations")
+		if len(karpenterInstanceIds) == 0 {
+			return nil
+		}
+		if round == karpenterMaxTerminateRounds {
+			return fmt.Errorf("instances still being launched by Karpenter for cluster %q: %s", clusterName, strings.Join(karpenterInstanceIds, ", "))
+		}
+		if err := terminateInstancesInReservations(ctx, client, reservations, force); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	kubernetesClusterTagValueOwned  = "owned"
+	kubernetesClusterTagValueShared = "shared"
+)
+
+// kubernetesClusterTagKey returns the tag key that Kubernetes uses to mark AWS
+// resources as belonging to the cluster clusterName.
+func kubernetesClusterTagKey(clusterName string) string {
+	return "kubernetes.io/cluster/" + clusterName
+}
+
+// ec2ClusterTagFilter returns filters that match EC2 resources tagged as owned
+// by the Kubernetes cluster clusterName. Resources tagged as shared with the
+// cluster are not matched.
+func ec2ClusterTagFilter(clusterName string) []ec2types.Filter {
+	return []ec2types.Filter{
+		{
+			Name:   aws.String("tag:" + kubernetesClusterTagKey(clusterName)),
+			Values: []string{kubernetesClusterTagValueOwned},
+		},
+	}
+}
+
+// kubernetesClusterTagKeys returns the tag keys that Kubernetes and its
+// tooling use to mark AWS resources as belonging to the cluster clusterName.
+func kubernetesClusterTagKeys(clusterName string) []string {
+	return []string{
+		kubernetesClusterTagKey(clusterName),
+		"k8s.io/cluster/" + clusterName,
+	}
+}
+
+// isSharedWithCluster returns whether tags mark a resource as shared with,
+// rather than owned by, the cluster clusterName. Shared resources are used by
+// the cluster but may also be used by other clusters, so must not be deleted.
+func isSharedWithCluster(tags []ec2types.Tag, clusterName string) bool {
+	if clusterName == "" {
+		return false
+	}
+	tagKeys := newStringSet(kubernetesClusterTagKeys(clusterName)...)
+	for _, tag := range tags {
+		if tag.Key == nil || tag.Value == nil || !tagKeys.contains(*tag.Key) {
+			continue
+		}
+		if *tag.Value == kubernetesClusterTagValueShared {
+			return true
+		}
+	}
+	return false
+}
+
+// listSharedVpcResourceIds returns the IDs of the VPC with ID vpcId and of its
+// Subnets, SecurityGroups, RouteTables, NetworkInterfaces, and Instances that
+// are tagged as shared with the cluster clusterName.
+func listSharedVpcResourceIds(ctx context.Context, client *ec2.Client, clusterName, vpcId string) ([]string, error) {
+	var sharedResourceIds []string
+
+	vpcs, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: ec2VpcFilter(vpcId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, vpc := range vpcs.Vpcs {
+		if vpc.VpcId != nil && isSharedWithCluster(vpc.Tags, clusterName) {
+			sharedResourceIds = append(sharedResourceIds, *vpc.VpcId)
+		}
+	}
+
+	subnets, err := listSubnets(ctx, client, vpcId)
+	if err != nil {
+		return nil, err
+	}
+	_, sharedSubnets := partitionSharedSubnets(subnets, clusterName)
+	sharedResourceIds = append(sharedResourceIds, subnetIds(sharedSubnets)...)
+
+	securityGroups, err := listNonDefaultSecurityGroups(ctx, client, ec2VpcFilter(vpcId))
+	if err != nil {
+		return nil, err
+	}
+	_, sharedSecurityGroups := partitionSharedSecurityGroups(securityGroups, clusterName)
+	sharedResourceIds = append(sharedResourceIds, securityGroupIds(sharedSecurityGroups)...)
+
+	routeTables, err := listRouteTables(ctx, client, vpcId)
+	if err != nil {
+		return nil, err
+	}
+	_, sharedRouteTables := partitionSharedRouteTables(routeTables, clusterName)
+	sharedResourceIds = append(sharedResourceIds, routeTableIds(sharedRouteTables)...)
+
+	networkInterfaces, err := listNetworkInterfaces(ctx, client, ec2VpcFilter(vpcId))
+	if err != nil {
+		return nil, err
+	}
+	for _, networkInterface := range networkInterfaces {
+		if networkInterface.NetworkInterfaceId != nil && isSharedWithCluster(networkInterface.TagSet, clusterName) {
+			sharedResourceIds = append(sharedResourceIds, *networkInterface.NetworkInterfaceId)
+		}
+	}
+
+	reservations, err := listReservations(ctx, client, ec2VpcFilter(vpcId))
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range reservations {
+		for _, instance := range reservation.Instances {
+			if instance.InstanceId == nil || instance.State == nil || instance.State.Name == ec2types.InstanceStateNameTerminated {
+				continue
+			}
+			if isSharedWithCluster(instance.Tags, clusterName) {
+				sharedResourceIds = append(sharedResourceIds, *instance.InstanceId)
+			}
+		}
+	}
+
+	return sharedResourceIds, nil
+}
+
+// untagSharedResources removes the cluster clusterName's tags from the EC2
+// resources with IDs resourceIds, leaving the resources themselves intact.
+func untagSharedResources(ctx context.Context, client *ec2.Client, clusterName string, resourceIds []string) error {
+	tags := make([]ec2types.Tag, 0, 2)
+	for _, tagKey := range kubernetesClusterTagKeys(clusterName) {
+		tags = append(tags, ec2types.Tag{
+			Key: aws.String(tagKey),
+		})
+	}
+	_, err := client.DeleteTags(ctx, &ec2.DeleteTagsInput{
+		Resources: resourceIds,
+		Tags:      tags,
+	})
+	log.Err(err).
+		Strs("Resources", resourceIds).
+		Msg("DeleteTags")
+	return err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func TestIsSharedWithCluster(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		tags        []ec2types.Tag
+		clusterName string
+		expected    bool
+	}{
+		{
+			name:        "no_tags",
+			clusterName: "test",
+		},
+		{
+			name: "no_cluster_name",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/"), Value: aws.String("shared")},
+			},
+		},
+		{
+			name: "owned",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("owned")},
+			},
+			clusterName: "test",
+		},
+		{
+			name: "shared",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("shared")},
+			},
+			clusterName: "test",
+			expected:    true,
+		},
+		{
+			name: "shared_k8s_io",
+			tags: []ec2types.Tag{
+				{Key: aws.String("k8s.io/cluster/test"), Value: aws.String("shared")},
+			},
+			clusterName: "test",
+			expected:    true,
+		},
+		{
+			name: "shared_with_other_cluster",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/other"), Value: aws.String("shared")},
+			},
+			clusterName: "test",
+		},
+		{
+			name: "shared_with_cluster_name_prefix",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/test-2"), Value: aws.String("shared")},
+			},
+			clusterName: "test",
+		},
+		{
+			name: "nil_value",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/test")},
+			},
+			clusterName: "test",
+		},
+		{
+			name: "owned_and_shared",
+			tags: []ec2types.Tag{
+				{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("owned")},
+				{Key: aws.String("k8s.io/cluster/test"), Value: aws.String("shared")},
+			},
+			clusterName: "test",
+			expected:    true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isSharedWithCluster(tc.tags, tc.clusterName); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPartitionSharedRouteTables(t *testing.T) {
+	owned := ec2types.RouteTable{
+		RouteTableId: aws.String("rtb-owned"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("owned")},
+		},
+	}
+	shared := ec2types.RouteTable{
+		RouteTableId: aws.String("rtb-shared"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("shared")},
+		},
+	}
+	untagged := ec2types.RouteTable{
+		RouteTableId: aws.String("rtb-untagged"),
+	}
+
+	for _, tc := range []struct {
+		name           string
+		routeTables    []ec2types.RouteTable
+		clusterName    string
+		expectedOwned  []string
+		expectedShared []string
+	}{
+		{
+			name:        "empty",
+			clusterName: "test",
+		},
+		{
+			name:          "no_cluster_name",
+			routeTables:   []ec2types.RouteTable{owned, shared, untagged},
+			expectedOwned: []string{"rtb-owned", "rtb-shared", "rtb-untagged"},
+		},
+		{
+			name:           "mixed",
+			routeTables:    []ec2types.RouteTable{owned, shared, untagged},
+			clusterName:    "test",
+			expectedOwned:  []string{"rtb-owned", "rtb-untagged"},
+			expectedShared: []string{"rtb-shared"},
+		},
+		{
+			name:          "other_cluster",
+			routeTables:   []ec2types.RouteTable{owned, shared, untagged},
+			clusterName:   "other",
+			expectedOwned: []string{"rtb-owned", "rtb-shared", "rtb-untagged"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actualOwned, actualShared := partitionSharedRouteTables(tc.routeTables, tc.clusterName)
+			assertStrings(t, tc.expectedOwned, routeTableIds(actualOwned))
+			assertStrings(t, tc.expectedShared, routeTableIds(actualShared))
+		})
+	}
+}
+
+func TestPartitionSharedSecurityGroups(t *testing.T) {
+	owned := ec2types.SecurityGroup{
+		GroupId: aws.String("sg-owned"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("owned")},
+		},
+	}
+	shared := ec2types.SecurityGroup{
+		GroupId: aws.String("sg-shared"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("k8s.io/cluster/test"), Value: aws.String("shared")},
+		},
+	}
+	untagged := ec2types.SecurityGroup{
+		GroupId: aws.String("sg-untagged"),
+	}
+
+	for _, tc := range []struct {
+		name           string
+		securityGroups []ec2types.SecurityGroup
+		clusterName    string
+		expectedOwned  []string
+		expectedShared []string
+	}{
+		{
+			name:        "empty",
+			clusterName: "test",
+		},
+		{
+			name:           "no_cluster_name",
+			securityGroups: []ec2types.SecurityGroup{owned, shared, untagged},
+			expectedOwned:  []string{"sg-owned", "sg-shared", "sg-untagged"},
+		},
+		{
+			name:           "mixed",
+			securityGroups: []ec2types.SecurityGroup{owned, shared, untagged},
+			clusterName:    "test",
+			expectedOwned:  []string{"sg-owned", "sg-untagged"},
+			expectedShared: []string{"sg-shared"},
+		},
+		{
+			name:           "all_shared",
+			securityGroups: []ec2types.SecurityGroup{shared},
+			clusterName:    "test",
+			expectedShared: []string{"sg-shared"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actualOwned, actualShared := partitionSharedSecurityGroups(tc.securityGroups, tc.clusterName)
+			assertStrings(t, tc.expectedOwned, securityGroupIds(actualOwned))
+			assertStrings(t, tc.expectedShared, securityGroupIds(actualShared))
+		})
+	}
+}
+
+func TestPartitionSharedSubnets(t *testing.T) {
+	owned := ec2types.Subnet{
+		SubnetId: aws.String("subnet-owned"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("owned")},
+		},
+	}
+	shared := ec2types.Subnet{
+		SubnetId: aws.String("subnet-shared"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/test"), Value: aws.String("shared")},
+		},
+	}
+	sharedWithOther := ec2types.Subnet{
+		SubnetId: aws.String("subnet-shared-with-other"),
+		Tags: []ec2types.Tag{
+			{Key: aws.String("kubernetes.io/cluster/other"), Value: aws.String("shared")},
+		},
+	}
+
+	for _, tc := range []struct {
+		name           string
+		subnets        []ec2types.Subnet
+		clusterName    string
+		expectedOwned  []string
+		expectedShared []string
+	}{
+		{
+			name:        "empty",
+			clusterName: "test",
+		},
+		{
+			name:          "no_cluster_name",
+			subnets:       []ec2types.Subnet{owned, shared, sharedWithOther},
+			expectedOwned: []string{"subnet-owned", "subnet-shared", "subnet-shared-with-other"},
+		},
+		{
+			name:           "mixed",
+			subnets:        []ec2types.Subnet{owned, shared, sharedWithOther},
+			clusterName:    "test",
+			expectedOwned:  []string{"subnet-owned", "subnet-shared-with-other"},
+			expectedShared: []string{"subnet-shared"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actualOwned, actualShared := partitionSharedSubnets(tc.subnets, tc.clusterName)
+			assertStrings(t, tc.expectedOwned, subnetIds(actualOwned))
+			assertStrings(t, tc.expectedShared, subnetIds(actualShared))
+		})
+	}
+}
+
+func TestExcludeSubnets(t *testing.T) {
+	subnets := []ec2types.Subnet{
+		{SubnetId: aws.String("subnet-1")},
+		{SubnetId: aws.String("subnet-2")},
+		{SubnetId: aws.String("subnet-3")},
+	}
+
+	for _, tc := range []struct {
+		name              string
+		excludedSubnetIds stringSet
+		expectedIncluded  []string
+		expectedExcluded  []string
+	}{
+		{
+			name:             "nil",
+			expectedIncluded: []string{"subnet-1", "subnet-2", "subnet-3"},
+		},
+		{
+			name:              "mixed",
+			excludedSubnetIds: newStringSet("subnet-2", "vpc-1"),
+			expectedIncluded:  []string{"subnet-1", "subnet-3"},
+			expectedExcluded:  []string{"subnet-2"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actualIncluded, actualExcluded := excludeSubnets(subnets, tc.excludedSubnetIds)
+			assertStrings(t, tc.expectedIncluded, subnetIds(actualIncluded))
+			assertStrings(t, tc.expectedExcluded, subnetIds(actualExcluded))
+		})
+	}
+}
+
+// assertStrings fails t if actual is not equal to expected, treating nil and
+// empty slices as equal.
+func assertStrings(t *testing.T, expected, actual []string) {
+	t.Helper()
+	if len(expected) == 0 && len(actual) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
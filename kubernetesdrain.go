@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubernetesDrainPollInterval is the interval at which the Kubernetes API is
+// polled while waiting for the cloud controllers to delete AWS resources.
+const kubernetesDrainPollInterval = 10 * time.Second
+
+// kubernetesNodeScalers are the names of Deployments that launch nodes and so
+// must be scaled down before the cluster is drained.
+var kubernetesNodeScalers = newStringSet(
+	"aws-cluster-autoscaler",
+	"cluster-autoscaler",
+	"karpenter",
+)
+
+// drainKubernetesCluster deletes the Kubernetes objects whose controllers
+// create AWS resources in the VPC, so that the controllers delete those
+// resources themselves rather than recreating them while the VPC is being
+// deleted. It scales down node autoscalers, deletes Services of type
+// LoadBalancer, Ingresses, and PersistentVolumeClaims, and then waits up to
+// timeout for them and their dynamically provisioned PersistentVolumes to be
+// deleted. PersistentVolumeClaims that are mounted by running Pods are skipped,
+// as the pvc-protection finalizer prevents their deletion until the Pods are
+// deleted. Their Volumes are deleted with the cluster's other Volumes once the
+// Instances are terminated.
+func drainKubernetesCluster(ctx context.Context, client kubernetes.Interface, timeout time.Duration) error {
+	if err := scaleDownKubernetesNodeScalers(ctx, client); err != nil {
+		return err
+	}
+	inUsePersistentVolumeClaims, err := listInUsePersistentVolumeClaims(ctx, client)
+	if err != nil {
+		return err
+	}
+	if len(inUsePersistentVolumeClaims) > 0 {
+		log.Info().
+			Str("persistentVolumeClaims", inUsePersistentVolumeClaims.String()).
+			Msg("skipping in-use PersistentVolumeClaims")
+	}
+	deletedPersistentVolumeClaims, err := deleteKubernetesObjects(ctx, client, inUsePersistentVolumeClaims)
+	if err != nil {
+		return err
+	}
+
+	var remaining []string
+	err = poll(ctx, kubernetesDrainPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		var err error
+		remaining, err = listRemainingKubernetesObjects(ctx, client, inUsePersistentVolumeClaims, deletedPersistentVolumeClaims)
+		if err != nil {
+			return false, err
+		}
+		log.Info().
+			Strs("remaining", remaining).
+			Msg("listRemainingKubernetesObjects")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		return fmt.Errorf("timed out waiting for Kubernetes objects to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return err
+}
+
+// deleteKubernetesObjects deletes all Services of type LoadBalancer, Ingresses,
+// and PersistentVolumeClaims in all namespaces, except the
+// PersistentVolumeClaims in skipPersistentVolumeClaims, a set of namespaced
+// names. It returns the namespaced names of the PersistentVolumeClaims that
+// are being deleted and accumulates errors.
+func deleteKubernetesObjects(ctx context.Context, client kubernetes.Interface, skipPersistentVolumeClaims stringSet) (deletedPersistentVolumeClaims stringSet, errs error) {
+	services, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.DeletionTimestamp != nil {
+			continue
+		}
+		err := client.CoreV1().Services(service.Namespace).Delete(ctx, service.Name, metav1.DeleteOptions{})
+		log.Err(err).
+			Str("Namespace", service.Namespace).
+			Str("Name", service.Name).
+			Msg("DeleteService")
+		errs = multierr.Append(errs, err)
+	}
+
+	ingresses, err := client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, multierr.Append(errs, err)
+	}
+	for _, ingress := range ingresses.Items {
+		if ingress.DeletionTimestamp != nil {
+			continue
+		}
+		err := client.NetworkingV1().Ingresses(ingress.Namespace).Delete(ctx, ingress.Name, metav1.DeleteOptions{})
+		log.Err(err).
+			Str("Namespace", ingress.Namespace).
+			Str("Name", ingress.Name).
+			Msg("DeleteIngress")
+		errs = multierr.Append(errs, err)
+	}
+
+	persistentVolumeClaims, err := client.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, multierr.Append(errs, err)
+	}
+	deletedPersistentVolumeClaims = newStringSet()
+	for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
+		namespacedName := persistentVolumeClaim.Namespace + "/" + persistentVolumeClaim.Name
+		if skipPersistentVolumeClaims.contains(namespacedName) {
+			continue
+		}
+		if persistentVolumeClaim.DeletionTimestamp != nil {
+			deletedPersistentVolumeClaims[namespacedName] = struct{}{}
+			continue
+		}
+		err := client.CoreV1().PersistentVolumeClaims(persistentVolumeClaim.Namespace).Delete(ctx, persistentVolumeClaim.Name, metav1.DeleteOptions{})
+		log.Err(err).
+			Str("Namespace", persistentVolumeClaim.Namespace).
+			Str("Name", persistentVolumeClaim.Name).
+			Msg("DeletePersistentVolumeClaim")
+		if err == nil {
+			deletedPersistentVolumeClaims[namespacedName] = struct{}{}
+		}
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// listInUsePersistentVolumeClaims returns the namespaced names of the
+// PersistentVolumeClaims mounted by Pods that have not terminated.
+func listInUsePersistentVolumeClaims(ctx context.Context, client kubernetes.Interface) (stringSet, error) {
+	pods, err := client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	inUsePersistentVolumeClaims := newStringSet()
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				inUsePersistentVolumeClaims[pod.Namespace+"/"+volume.PersistentVolumeClaim.ClaimName] = struct{}{}
+			}
+		}
+	}
+	return inUsePersistentVolumeClaims, nil
+}
+
+// listRemainingKubernetesObjects returns the namespaced names of the objects
+// deleted by deleteKubernetesObjects that still exist, including any
+// PersistentVolumes that will be deleted by their provisioner, that is those
+// with the Delete reclaim policy that are bound to a PersistentVolumeClaim in
+// deletedPersistentVolumeClaims or that have already been released. The
+// PersistentVolumeClaims in skipPersistentVolumeClaims and their
+// PersistentVolumes are ignored.
+func listRemainingKubernetesObjects(ctx context.Context, client kubernetes.Interface, skipPersistentVolumeClaims, deletedPersistentVolumeClaims stringSet) ([]string, error) {
+	var remaining []string
+
+	services, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services.Items {
+		if service.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			remaining = append(remaining, "service/"+service.Namespace+"/"+service.Name)
+		}
+	}
+
+	ingresses, err := client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, ingress := range ingresses.Items {
+		remaining = append(remaining, "ingress/"+ingress.Namespace+"/"+ingress.Name)
+	}
+
+	persistentVolumeClaims, err := client.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
+		if skipPersistentVolumeClaims.contains(persistentVolumeClaim.Namespace + "/" + persistentVolumeClaim.Name) {
+			continue
+		}
+		remaining = append(remaining, "persistentvolumeclaim/"+persistentVolumeClaim.Namespace+"/"+persistentVolumeClaim.Name)
+	}
+
+	persistentVolumes, err := client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, persistentVolume := range persistentVolumes.Items {
+		if persistentVolume.Spec.PersistentVolumeReclaimPolicy != corev1.PersistentVolumeReclaimDelete {
+			continue
+		}
+		var claimNamespacedName string
+		if claimRef := persistentVolume.Spec.ClaimRef; claimRef != nil {
+			claimNamespacedName = claimRef.Namespace + "/" + claimRef.Name
+		}
+		if skipPersistentVolumeClaims.contains(claimNamespacedName) {
+			continue
+		}
+		if deletedPersistentVolumeClaims.contains(claimNamespacedName) || persistentVolume.Status.Phase == corev1.VolumeReleased {
+			remaining = append(remaining, "persistentvolume/"+persistentVolume.Name)
+		}
+	}
+
+	return remaining, nil
+}
+
+// newKubernetesClientFromKubeconfig returns a new Kubernetes client using the
+// kubeconfig file at path.
+func newKubernetesClientFromKubeconfig(path string) (kubernetes.Interface, error) {
+	config, err := clientcmd.BuildConfigFromFlags("", path)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// scaleDownKubernetesNodeScalers scales the Deployments of cluster-autoscaler
+// and Karpenter to zero replicas so that they do not launch new nodes while
+// the cluster is being deleted. It accumulates errors.
+func scaleDownKubernetesNodeScalers(ctx context.Context, client kubernetes.Interface) (errs error) {
+	deployments, err := client.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments.Items {
+		if !kubernetesNodeScalers.contains(deployment.Name) && !kubernetesNodeScalers.contains(deployment.Labels["app.kubernetes.io/name"]) {
+			continue
+		}
+		if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+			continue
+		}
+		_, err := client.AppsV1().Deployments(deployment.Namespace).UpdateScale(ctx, deployment.Name, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            deployment.Name,
+				Namespace:       deployment.Namespace,
+				ResourceVersion: deployment.ResourceVersion,
+			},
+			Spec: autoscalingv1.ScaleSpec{
+				Replicas: 0,
+			},
+		}, metav1.UpdateOptions{})
+		log.Err(err).
+			Str("Namespace", deployment.Namespace).
+			Str("Name", deployment.Name).
+			Msg("UpdateScale")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
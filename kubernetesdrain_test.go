@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestScaleDownKubernetesNodeScalers(t *testing.T) {
+	newDeployment := func(namespace, name string, labels map[string]string, replicas int32) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+				Labels:    labels,
+			},
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+			},
+		}
+	}
+
+	client := fake.NewSimpleClientset(
+		newDeployment("kube-system", "cluster-autoscaler", nil, 1),
+		newDeployment("karpenter", "controller", map[string]string{"app.kubernetes.io/name": "karpenter"}, 2),
+		newDeployment("kube-system", "aws-cluster-autoscaler", nil, 0),
+		newDeployment("kube-system", "coredns", nil, 2),
+	)
+	var scaled []string
+	client.PrependReactor("update", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "scale" {
+			return false, nil, nil
+		}
+		scale := action.(k8stesting.UpdateAction).GetObject().(*autoscalingv1.Scale)
+		if scale.Spec.Replicas != 0 {
+			t.Errorf("%s/%s: expected 0 replicas, got %d", scale.Namespace, scale.Name, scale.Spec.Replicas)
+		}
+		scaled = append(scaled, scale.Namespace+"/"+scale.Name)
+		return true, scale, nil
+	})
+
+	if err := scaleDownKubernetesNodeScalers(context.Background(), client); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(scaled)
+	assertStrings(t, []string{"karpenter/controller", "kube-system/cluster-autoscaler"}, scaled)
+}
+
+func TestDrainKubernetesCluster(t *testing.T) {
+	persistentVolumeClaim := func(namespace, name string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+		}
+	}
+	persistentVolume := func(name string, reclaimPolicy corev1.PersistentVolumeReclaimPolicy, claimNamespace, claimName string) *corev1.PersistentVolume {
+		persistentVolume := &corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: reclaimPolicy,
+			},
+		}
+		if claimName != "" {
+			persistentVolume.Spec.ClaimRef = &corev1.ObjectReference{
+				Namespace: claimNamespace,
+				Name:      claimName,
+			}
+		}
+		return persistentVolume
+	}
+	pod := func(namespace, name string, phase corev1.PodPhase, claimName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "data",
+						VolumeSource: corev1.VolumeSource{
+							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+								ClaimName: claimName,
+							},
+						},
+					},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase: phase,
+			},
+		}
+	}
+	service := func(namespace, name string, serviceType corev1.ServiceType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      name,
+			},
+			Spec: corev1.ServiceSpec{
+				Type: serviceType,
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name                           string
+		objects                        []runtime.Object
+		expectedErr                    string
+		expectedServices               []string
+		expectedIngresses              []string
+		expectedPersistentVolumeClaims []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "services",
+			objects: []runtime.Object{
+				service("default", "cluster-ip", corev1.ServiceTypeClusterIP),
+				service("default", "load-balancer", corev1.ServiceTypeLoadBalancer),
+				service("kube-system", "node-port", corev1.ServiceTypeNodePort),
+			},
+			expectedServices: []string{"default/cluster-ip", "kube-system/node-port"},
+		},
+		{
+			name: "ingresses",
+			objects: []runtime.Object{
+				&networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace: "default",
+						Name:      "ingress",
+					},
+				},
+			},
+		},
+		{
+			name: "persistent_volume_claims",
+			objects: []runtime.Object{
+				persistentVolumeClaim("default", "unused"),
+				persistentVolumeClaim("default", "used-by-completed-pod"),
+				persistentVolumeClaim("default", "used-by-running-pod"),
+				pod("default", "completed", corev1.PodSucceeded, "used-by-completed-pod"),
+				pod("default", "running", corev1.PodRunning, "used-by-running-pod"),
+				persistentVolume("pv-retain", corev1.PersistentVolumeReclaimRetain, "default", "unused"),
+				persistentVolume("pv-used-by-running-pod", corev1.PersistentVolumeReclaimDelete, "default", "used-by-running-pod"),
+			},
+			expectedPersistentVolumeClaims: []string{"default/used-by-running-pod"},
+		},
+		{
+			name: "unbound_persistent_volumes",
+			objects: []runtime.Object{
+				persistentVolume("pv-available", corev1.PersistentVolumeReclaimDelete, "", ""),
+				persistentVolume("pv-bound-to-missing-claim", corev1.PersistentVolumeReclaimDelete, "default", "missing"),
+			},
+		},
+		{
+			name: "released_persistent_volume",
+			objects: []runtime.Object{
+				func() runtime.Object {
+					persistentVolume := persistentVolume("pv-released", corev1.PersistentVolumeReclaimDelete, "default", "deleted")
+					persistentVolume.Status.Phase = corev1.VolumeReleased
+					return persistentVolume
+				}(),
+			},
+			expectedErr: "timed out waiting for Kubernetes objects to be deleted: persistentvolume/pv-released",
+		},
+		{
+			name: "timeout",
+			objects: []runtime.Object{
+				persistentVolumeClaim("default", "unused"),
+				persistentVolume("pv-delete", corev1.PersistentVolumeReclaimDelete, "default", "unused"),
+			},
+			expectedErr: "timed out waiting for Kubernetes objects to be deleted: persistentvolume/pv-delete",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			client := fake.NewSimpleClientset(tc.objects...)
+
+			err := drainKubernetesCluster(ctx, client, time.Millisecond)
+			switch {
+			case tc.expectedErr == "" && err != nil:
+				t.Fatalf("expected no error, got %v", err)
+			case tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)):
+				t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+
+			services, err := client.CoreV1().Services(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actualServices []string
+			for _, service := range services.Items {
+				actualServices = append(actualServices, service.Namespace+"/"+service.Name)
+			}
+			sort.Strings(actualServices)
+			assertStrings(t, tc.expectedServices, actualServices)
+
+			ingresses, err := client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actualIngresses []string
+			for _, ingress := range ingresses.Items {
+				actualIngresses = append(actualIngresses, ingress.Namespace+"/"+ingress.Name)
+			}
+			assertStrings(t, tc.expectedIngresses, actualIngresses)
+
+			persistentVolumeClaims, err := client.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var actualPersistentVolumeClaims []string
+			for _, persistentVolumeClaim := range persistentVolumeClaims.Items {
+				actualPersistentVolumeClaims = append(actualPersistentVolumeClaims, persistentVolumeClaim.Namespace+"/"+persistentVolumeClaim.Name)
+			}
+			sort.Strings(actualPersistentVolumeClaims)
+			assertStrings(t, tc.expectedPersistentVolumeClaims, actualPersistentVolumeClaims)
+		})
+	}
+}
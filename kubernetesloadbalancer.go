@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteKubernetesLoadBalancers deletes the classic, Network, and Application
+// LoadBalancers created by Kubernetes Services for the cluster clusterName,
+// wherever they are in the region. Only LoadBalancers tagged as owned by the
+// cluster are deleted. It accumulates errors.
+func deleteKubernetesLoadBalancers(ctx context.Context, clients *clients, clusterName string) (errs error) {
+	clusterTagKey := kubernetesClusterTagKey(clusterName)
+
+	if loadBalancerDescriptions, err := listLoadBalancers(ctx, clients.elasticloadbalancing, ""); err != nil {
+		log.Err(err).Msg("listLoadBalancers")
+		errs = multierr.Append(errs, err)
+	} else if loadBalancerDescriptions, err := filterLoadBalancersByTag(ctx, clients.elasticloadbalancing, loadBalancerDescriptions, clusterTagKey, kubernetesClusterTagValueOwned); err != nil {
+		log.Err(err).Msg("filterLoadBalancersByTag")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("loadBalancerNames", loadBalancerNames(loadBalancerDescriptions)).
+			Msg("listKubernetesLoadBalancers")
+		if len(loadBalancerDescriptions) > 0 {
+			err := deleteLoadBalancers(ctx, clients.elasticloadbalancing, loadBalancerDescriptions)
+			log.Err(err).
+				Strs("loadBalancerNames", loadBalancerNames(loadBalancerDescriptions)).
+				Msg("deleteLoadBalancers")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if loadBalancers, err := listLoadBalancersV2ByTag(ctx, clients.elasticloadbalancingv2, clusterTagKey, kubernetesClusterTagValueOwned); err != nil {
+		log.Err(err).Msg("listLoadBalancersV2ByTag")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("loadBalancerNames", loadBalancerV2Names(loadBalancers)).
+			Msg("listKubernetesLoadBalancersV2")
+		if len(loadBalancers) > 0 {
+			err := deleteLoadBalancersV2(ctx, clients.elasticloadbalancingv2, loadBalancers)
+			log.Err(err).
+				Strs("loadBalancerNames", loadBalancerV2Names(loadBalancers)).
+				Msg("deleteLoadBalancersV2")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	return
+}
+
+// deleteKubernetesLoadBalancerSecurityGroups deletes the k8s-elb-*
+// SecurityGroups created by Kubernetes for the LoadBalancers of the cluster
+// clusterName and tagged as owned by it, wherever they are in the region.
+// Rules in other SecurityGroups that reference them, typically on the nodes'
+// SecurityGroups, are revoked first. It accumulates errors.
+func deleteKubernetesLoadBalancerSecurityGroups(ctx context.Context, clients *clients, clusterName string) (errs error) {
+	filters := append([]ec2types.Filter{
+		{
+			Name:   aws.String("group-name"),
+			Values: []string{"k8s-elb-*"},
+		},
+	}, ec2ClusterTagFilter(clusterName)...)
+	securityGroups, err := listNonDefaultSecurityGroups(ctx, clients.ec2, filters)
+	if err != nil {
+		log.Err(err).Msg("listKubernetesLoadBalancerSecurityGroups")
+		return err
+	}
+	log.Info().
+		Strs("securityGroupIds", securityGroupIds(securityGroups)).
+		Msg("listKubernetesLoadBalancerSecurityGroups")
+
+	for _, securityGroup := range securityGroups {
+		if securityGroup.GroupId == nil || securityGroup.VpcId == nil {
+			continue
+		}
+		if err := revokeSecurityGroupReferences(ctx, clients.ec2, *securityGroup.GroupId); err != nil {
+			log.Err(err).
+				Str("groupId", *securityGroup.GroupId).
+				Msg("revokeSecurityGroupReferences")
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		err := deleteSecurityGroups(ctx, clients.ec2, *securityGroup.VpcId, []ec2types.SecurityGroup{securityGroup})
+		log.Err(err).
+			Str("groupId", *securityGroup.GroupId).
+			Msg("deleteSecurityGroups")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// lambdaLatestVersion is the version of a Lambda function that refers to its
+// unpublished code and configuration.
+const lambdaLatestVersion = "$LATEST"
+
+// deleteLambdaFunctions releases the VPC configurations of functions, which
+// may include published versions. If deleteFunctions is true then the
+// functions are deleted, together with all of their versions and aliases.
+// Otherwise the VPC configuration is removed from the unpublished version of
+// each function and, as published versions cannot be modified, the published
+// versions are reported as errors.
+func deleteLambdaFunctions(ctx context.Context, client *lambda.Client, functions []types.FunctionConfiguration, deleteFunctions bool) error {
+	var errs error
+	var publishedFunctionArns []string
+	deletedFunctionNames := newStringSet()
+	for _, function := range functions {
+		if function.FunctionName == nil {
+			continue
+		}
+
+		// Delete the function and all its versions and aliases.
+		if deleteFunctions {
+			if deletedFunctionNames.contains(*function.FunctionName) {
+				continue
+			}
+			deletedFunctionNames[*function.FunctionName] = struct{}{}
+			_, err := client.DeleteFunction(ctx, &lambda.DeleteFunctionInput{
+				FunctionName: function.FunctionName,
+			})
+			log.Err(err).
+				Str("FunctionName", *function.FunctionName).
+				Msg("DeleteFunction")
+			errs = multierr.Append(errs, err)
+			continue
+		}
+
+		// Published versions cannot be modified.
+		if aws.ToString(function.Version) != lambdaLatestVersion {
+			publishedFunctionArns = append(publishedFunctionArns, aws.ToString(function.FunctionArn))
+			continue
+		}
+
+		// Remove the VPC configuration from the unpublished version.
+		_, err := client.UpdateFunctionConfiguration(ctx, &lambda.UpdateFunctionConfigurationInput{
+			FunctionName: function.FunctionName,
+			VpcConfig: &types.VpcConfig{
+				SecurityGroupIds: []string{},
+				SubnetIds:        []string{},
+			},
+		})
+		log.Err(err).
+			Str("FunctionName", *function.FunctionName).
+			Msg("UpdateFunctionConfiguration")
+		errs = multierr.Append(errs, err)
+	}
+
+	if len(publishedFunctionArns) > 0 {
+		errs = multierr.Append(errs, fmt.Errorf("published versions of Lambda functions cannot be removed from the VPC, pass -delete-lambda-functions to delete the functions: %s", strings.Join(publishedFunctionArns, ", ")))
+	}
+
+	return errs
+}
+
+// deleteLambdaFunctionsInVpc releases or deletes the Lambda functions in the
+// VPC vpcId and then records Lambda's NetworkInterfaces in the VPC in opts, to
+// be waited for, for up to opts.lambdaNetworkInterfaceTimeout, once all other
+// services have been deleted. Lambda can take tens of minutes to delete them,
+// and they cannot be deleted directly. It accumulates errors.
+func deleteLambdaFunctionsInVpc(ctx context.Context, client *lambda.Client, ec2Client *ec2.Client, vpcId string, opts *options) (errs error) {
+	functions, err := listLambdaFunctions(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listLambdaFunctions")
+		return err
+	}
+	log.Info().
+		Strs("functionArns", lambdaFunctionArns(functions)).
+		Msg("listLambdaFunctions")
+	if len(functions) > 0 {
+		err := deleteLambdaFunctions(ctx, client, functions, opts.deleteLambdaFunctions)
+		log.Err(err).
+			Strs("functionArns", lambdaFunctionArns(functions)).
+			Msg("deleteLambdaFunctions")
+		errs = multierr.Append(errs, err)
+	}
+
+	err = addPendingNetworkInterfaces(ctx, ec2Client, append(ec2VpcFilter(vpcId), ec2types.Filter{
+		Name:   aws.String("interface-type"),
+		Values: []string{string(ec2types.NetworkInterfaceTypeLambda)},
+	}), opts.lambdaNetworkInterfaceTimeout, opts)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("addPendingNetworkInterfaces")
+	return multierr.Append(errs, err)
+}
+
+func lambdaFunctionArns(functions []types.FunctionConfiguration) []string {
+	functionArns := make([]string, 0, len(functions))
+	for _, function := range functions {
+		if function.FunctionArn != nil {
+			functionArns = append(functionArns, *function.FunctionArn)
+		}
+	}
+	return functionArns
+}
+
+// listLambdaFunctions returns all versions of the Lambda functions that are
+// connected to the VPC vpcId.
+func listLambdaFunctions(ctx context.Context, client *lambda.Client, vpcId string) ([]types.FunctionConfiguration, error) {
+	input := lambda.ListFunctionsInput{
+		FunctionVersion: types.FunctionVersionAll,
+	}
+	var functions []types.FunctionConfiguration
+	for {
+		output, err := client.ListFunctions(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, function := range output.Functions {
+			if function.VpcConfig != nil && aws.ToString(function.VpcConfig.VpcId) == vpcId {
+				functions = append(functions, function)
+			}
+		}
+		if output.NextMarker == nil {
+			return functions, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
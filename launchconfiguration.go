@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func deleteLaunchConfigurations(ctx context.Context, client *autoscaling.Client, launchConfigurationNames []string) (errs error) {
+	for _, launchConfigurationName := range launchConfigurationNames {
+		launchConfigurationName := launchConfigurationName
+		_, err := client.DeleteLaunchConfiguration(ctx, &autoscaling.DeleteLaunchConfigurationInput{
+			LaunchConfigurationName: &launchConfigurationName,
+		})
+		log.Err(err).
+			Str("LaunchConfigurationName", launchConfigurationName).
+			Msg("DeleteLaunchConfiguration")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// listLaunchConfigurationNames returns the names in launchConfigurationNames of
+// the LaunchConfigurations that exist.
+func listLaunchConfigurationNames(ctx context.Context, client *autoscaling.Client, launchConfigurationNames []string) ([]string, error) {
+	// DescribeLaunchConfigurations accepts at most 50 LaunchConfigurationNames
+	// at a time.
+	const maxLaunchConfigurationNames = 50
+	var existingLaunchConfigurationNames []string
+	for len(launchConfigurationNames) > 0 {
+		n := len(launchConfigurationNames)
+		if n > maxLaunchConfigurationNames {
+			n = maxLaunchConfigurationNames
+		}
+		input := autoscaling.DescribeLaunchConfigurationsInput{
+			LaunchConfigurationNames: launchConfigurationNames[:n],
+		}
+		for {
+			output, err := client.DescribeLaunchConfigurations(ctx, &input)
+			if err != nil {
+				return nil, err
+			}
+			for _, launchConfiguration := range output.LaunchConfigurations {
+				if launchConfiguration.LaunchConfigurationName != nil {
+					existingLaunchConfigurationNames = append(existingLaunchConfigurationNames, *launchConfiguration.LaunchConfigurationName)
+				}
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+		launchConfigurationNames = launchConfigurationNames[n:]
+	}
+	return existingLaunchConfigurationNames, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func deleteLaunchTemplates(ctx context.Context, client *ec2.Client, launchTemplates []types.LaunchTemplate) (errs error) {
+	for _, launchTemplate := range launchTemplates {
+		if launchTemplate.LaunchTemplateId == nil {
+			continue
+		}
+		_, err := client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: launchTemplate.LaunchTemplateId,
+		})
+		log.Err(err).
+			Str("LaunchTemplateId", *launchTemplate.LaunchTemplateId).
+			Msg("DeleteLaunchTemplate")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+func launchTemplateIds(launchTemplates []types.LaunchTemplate) []string {
+	launchTemplateIds := make([]string, 0, len(launchTemplates))
+	for _, launchTemplate := range launchTemplates {
+		if launchTemplate.LaunchTemplateId != nil {
+			launchTemplateIds = append(launchTemplateIds, *launchTemplate.LaunchTemplateId)
+		}
+	}
+	return launchTemplateIds
+}
+
+func listLaunchTemplates(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.LaunchTemplate, error) {
+	input := ec2.DescribeLaunchTemplatesInput{
+		Filters: filters,
+	}
+	var launchTemplates []types.LaunchTemplate
+	for {
+		output, err := client.DescribeLaunchTemplates(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		launchTemplates = append(launchTemplates, output.LaunchTemplates...)
+		if output.NextToken == nil {
+			return launchTemplates, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
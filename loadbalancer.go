@@ -25,6 +25,48 @@ func deleteLoadBalancers(ctx context.Context, client *elasticloadbalancing.Clien
 	return
 }
 
+// filterLoadBalancersByTag returns the loadBalancerDescriptions that have a tag
+// with key tagKey and value tagValue.
+func filterLoadBalancersByTag(ctx context.Context, client *elasticloadbalancing.Client, loadBalancerDescriptions []types.LoadBalancerDescription, tagKey, tagValue string) ([]types.LoadBalancerDescription, error) {
+	// DescribeTags accepts at most 20 LoadBalancerNames at a time.
+	const maxLoadBalancerNames = 20
+	names := loadBalancerNames(loadBalancerDescriptions)
+	taggedLoadBalancerNames := newStringSet()
+	for len(names) > 0 {
+		n := len(names)
+		if n > maxLoadBalancerNames {
+			n = maxLoadBalancerNames
+		}
+		output, err := client.DescribeTags(ctx, &elasticloadbalancing.DescribeTagsInput{
+			LoadBalancerNames: names[:n],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, tagDescription := range output.TagDescriptions {
+			if tagDescription.LoadBalancerName == nil {
+				continue
+			}
+			for _, tag := range tagDescription.Tags {
+				if tag.Key != nil && *tag.Key == tagKey && tag.Value != nil && *tag.Value == tagValue {
+					taggedLoadBalancerNames[*tagDescription.LoadBalancerName] = struct{}{}
+				}
+			}
+		}
+		names = names[n:]
+	}
+
+	var taggedLoadBalancerDescriptions []types.LoadBalancerDescription
+	for _, loadBalancerDescription := range loadBalancerDescriptions {
+		if loadBalancerDescription.LoadBalancerName != nil && taggedLoadBalancerNames.contains(*loadBalancerDescription.LoadBalancerName) {
+			taggedLoadBalancerDescriptions = append(taggedLoadBalancerDescriptions, loadBalancerDescription)
+		}
+	}
+	return taggedLoadBalancerDescriptions, nil
+}
+
+// listLoadBalancers returns the classic LoadBalancers in the VPC with ID vpcId,
+// or all classic LoadBalancers in the region if vpcId is empty.
 func listLoadBalancers(ctx context.Context, client *elasticloadbalancing.Client, vpcId string) ([]types.LoadBalancerDescription, error) {
 	input := elasticloadbalancing.DescribeLoadBalancersInput{}
 	var loadBalancerDescriptions []types.LoadBalancerDescription
@@ -34,7 +76,7 @@ func listLoadBalancers(ctx context.Context, client *elasticloadbalancing.Client,
 			return nil, err
 		}
 		for _, loadBalancerDescription := range output.LoadBalancerDescriptions {
-			if loadBalancerDescription.VPCId == nil || *loadBalancerDescription.VPCId != vpcId {
+			if vpcId != "" && (loadBalancerDescription.VPCId == nil || *loadBalancerDescription.VPCId != vpcId) {
 				continue
 			}
 			loadBalancerDescriptions = append(loadBalancerDescriptions, loadBalancerDescription)
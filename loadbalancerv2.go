@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+func deleteLoadBalancersV2(ctx context.Context, client *elasticloadbalancingv2.Client, loadBalancers []types.LoadBalancer) (errs error) {
+	var deletedLoadBalancerArns []string
+	for _, loadBalancer := range loadBalancers {
+		if loadBalancer.LoadBalancerArn == nil {
+			continue
+		}
+		_, err := client.DeleteLoadBalancer(ctx, &elasticloadbalancingv2.DeleteLoadBalancerInput{
+			LoadBalancerArn: loadBalancer.LoadBalancerArn,
+		})
+		log.Err(err).
+			Str("LoadBalancerArn", *loadBalancer.LoadBalancerArn).
+			Msg("DeleteLoadBalancer")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletedLoadBalancerArns = append(deletedLoadBalancerArns, *loadBalancer.LoadBalancerArn)
+		}
+	}
+
+	if len(deletedLoadBalancerArns) == 0 {
+		return
+	}
+
+	// Wait for the LoadBalancers to be deleted so that their NetworkInterfaces
+	// are released.
+	loadBalancersDeletedWaiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(client)
+	log.Info().
+		Strs("LoadBalancerArns", deletedLoadBalancerArns).
+		Msg("LoadBalancersDeletedWaiter.Wait")
+	err := loadBalancersDeletedWaiter.Wait(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: deletedLoadBalancerArns,
+	}, loadBalancersDeletedWaiterMaxDuration)
+	log.Err(err).
+		Msg("LoadBalancersDeletedWaiter.Wait")
+	errs = multierr.Append(errs, err)
+	return
+}
+
+// listLoadBalancersV2 returns the Application, Network, and Gateway
+// LoadBalancers in the VPC with ID vpcId.
+func listLoadBalancersV2(ctx context.Context, client *elasticloadbalancingv2.Client, vpcId string) ([]types.LoadBalancer, error) {
+	input := elasticloadbalancingv2.DescribeLoadBalancersInput{}
+	var loadBalancers []types.LoadBalancer
+	for {
+		output, err := client.DescribeLoadBalancers(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, loadBalancer := range output.LoadBalancers {
+			if loadBalancer.VpcId != nil && *loadBalancer.VpcId == vpcId {
+				loadBalancers = append(loadBalancers, loadBalancer)
+			}
+		}
+		if output.NextMarker == nil {
+			return loadBalancers, nil
+		}
+		input.Marker = output.NextMarker
+	}
+}
+
+// listLoadBalancersV2ByTag returns all Application, Network, and Gateway
+// LoadBalancers in the region that have a tag with key tagKey and value
+// tagValue.
+func listLoadBalancersV2ByTag(ctx context.Context, client *elasticloadbalancingv2.Client, tagKey, tagValue string) ([]types.LoadBalancer, error) {
+	input := elasticloadbalancingv2.DescribeLoadBalancersInput{}
+	var loadBalancers []types.LoadBalancer
+	for {
+		output, err := client.DescribeLoadBalancers(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		loadBalancers = append(loadBalancers, output.LoadBalancers...)
+		if output.NextMarker == nil {
+			break
+		}
+		input.Marker = output.NextMarker
+	}
+
+	// DescribeTags accepts at most 20 ResourceArns at a time.
+	const maxResourceArns = 20
+	arns := loadBalancerV2Arns(loadBalancers)
+	taggedLoadBalancerArns := newStringSet()
+	for len(arns) > 0 {
+		n := len(arns)
+		if n > maxResourceArns {
+			n = maxResourceArns
+		}
+		output, err := client.DescribeTags(ctx, &elasticloadbalancingv2.DescribeTagsInput{
+			ResourceArns: arns[:n],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, tagDescription := range output.TagDescriptions {
+			if tagDescription.ResourceArn == nil {
+				continue
+			}
+			for _, tag := range tagDescription.Tags {
+				if tag.Key != nil && *tag.Key == tagKey && tag.Value != nil && *tag.Value == tagValue {
+					taggedLoadBalancerArns[*tagDescription.ResourceArn] = struct{}{}
+				}
+			}
+		}
+		arns = arns[n:]
+	}
+
+	var taggedLoadBalancers []types.LoadBalancer
+	for _, loadBalancer := range loadBalancers {
+		if loadBalancer.LoadBalancerArn != nil && taggedLoadBalancerArns.contains(*loadBalancer.LoadBalancerArn) {
+			taggedLoadBalancers = append(taggedLoadBalancers, loadBalancer)
+		}
+	}
+	return taggedLoadBalancers, nil
+}
+
+func loadBalancerV2Arns(loadBalancers []types.LoadBalancer) []string {
+	loadBalancerArns := make([]string, 0, len(loadBalancers))
+	for _, loadBalancer := range loadBalancers {
+		if loadBalancer.LoadBalancerArn != nil {
+			loadBalancerArns = append(loadBalancerArns, *loadBalancer.LoadBalancerArn)
+		}
+	}
+	return loadBalancerArns
+}
+
+func loadBalancerV2Names(loadBalancers []types.LoadBalancer) []string {
+	loadBalancerNames := make([]string, 0, len(loadBalancers))
+	for _, loadBalancer := range loadBalancers {
+		if loadBalancer.LoadBalancerName != nil {
+			loadBalancerNames = append(loadBalancerNames, *loadBalancer.LoadBalancerName)
+		}
+	}
+	return loadBalancerNames
+}
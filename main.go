@@ -21,9 +21,77 @@ import (
 )
 
 const (
-	instanceTerminatedWaiterMaxDuration = 5 * time.Minute
+	apiGatewayVpcLinkDeletedWaiterMaxDuration     = 30 * time.Minute
+	apiGatewayVpcLinkPollInterval                 = 10 * time.Second
+	appRunnerVpcConnectorDeletedWaiterMaxDuration = 30 * time.Minute
+	autoScalingGroupDeletedWaiterMaxDuration      = 10 * time.Minute
+	autoScalingGroupPollInterval                  = 10 * time.Second
+	batchPollInterval                             = 10 * time.Second
+	batchWaiterMaxDuration                        = 10 * time.Minute
+	cacheClusterDeletedWaiterMaxDuration          = 30 * time.Minute
+	cacheClusterPollInterval                      = 30 * time.Second
+	clusterDeletedWaiterMaxDuration               = 15 * time.Minute
+	databaseAvailableWaiterMaxDuration            = 30 * time.Minute
+	databaseDeletedWaiterMaxDuration              = 30 * time.Minute
+	databasePollInterval                          = 30 * time.Second
+	directoryDeletedWaiterMaxDuration             = 30 * time.Minute
+	directoryPollInterval                         = 30 * time.Second
+	ecsTasksStoppedWaiterMaxDuration              = 10 * time.Minute
+	efsMountTargetDeletedWaiterMaxDuration        = 10 * time.Minute
+	emrClusterTerminatedWaiterMaxDuration         = 30 * time.Minute
+	fargateProfileDeletedWaiterMaxDuration        = 10 * time.Minute
+	instanceTerminatedWaiterMaxDuration           = 5 * time.Minute
+	loadBalancersDeletedWaiterMaxDuration         = 5 * time.Minute
+	mqBrokerDeletedWaiterMaxDuration              = 30 * time.Minute
+	mqBrokerPollInterval                          = 30 * time.Second
+	mskClusterDeletedWaiterMaxDuration            = 30 * time.Minute
+	mwaaEnvironmentDeletedWaiterMaxDuration       = 60 * time.Minute
+	mwaaEnvironmentPollInterval                   = 30 * time.Second
+	networkInterfacePollInterval                  = 10 * time.Second
+	nodegroupDeletedWaiterMaxDuration             = 15 * time.Minute
+	openSearchDomainDeletedWaiterMaxDuration      = 30 * time.Minute
+	openSearchDomainPollInterval                  = 30 * time.Second
+	redshiftDeletedWaiterMaxDuration              = 30 * time.Minute
+	redshiftPollInterval                          = 30 * time.Second
+	replicationInstanceDeletedWaiterMaxDuration   = 30 * time.Minute
+	replicationTaskWaiterMaxDuration              = 10 * time.Minute
+	sageMakerPollInterval                         = 30 * time.Second
+	sageMakerWaiterMaxDuration                    = 30 * time.Minute
+	securityGroupPollInterval                     = 10 * time.Second
 )
 
+// options are optional behaviors of the deletion steps.
+type options struct {
+	deleteEcsClusters                 bool
+	deleteEfsFileSystems              bool
+	deleteLambdaFunctions             bool
+	deleteLaunchTemplates             bool
+	deleteSageMakerHomeEfsFileSystems bool
+	finalSnapshotPrefix               string
+	force                             bool
+	forceDeleteAutoScalingGroups      bool
+	lambdaNetworkInterfaceTimeout     time.Duration
+	snapshotVolumes                   bool
+
+	// deletedAutoScalingGroups are the AutoScalingGroups deleted in earlier
+	// tries, so that their LaunchTemplates can still be deleted after the
+	// AutoScalingGroups themselves are gone.
+	deletedAutoScalingGroups []autoscalingtypes.AutoScalingGroup
+
+	// pendingNetworkInterfaceIds are the requester-managed NetworkInterfaces
+	// that services have been asked to release, and
+	// pendingNetworkInterfaceTimeout is how long to wait for them. They are
+	// waited for once all other services have been deleted, rather than after
+	// each service, and are carried across tries until they are deleted.
+	pendingNetworkInterfaceIds     stringSet
+	pendingNetworkInterfaceTimeout time.Duration
+
+	// sharedResourceIds are the resources in the VPC tagged as shared with
+	// the cluster, found before any tags are removed so that they are still
+	// skipped in later tries.
+	sharedResourceIds stringSet
+}
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
@@ -34,18 +102,38 @@ func main() {
 func run() error {
 	excludeResources := newStringSet()
 	includeResources := newStringSet(
+		"ApiGatewayVpcLinks",
+		"AppRunnerVpcConnectors",
 		"AutoScalingGroups",
+		"BatchComputeEnvironments",
+		"CacheClusters",
 		"Clusters",
+		"Databases",
+		"Directories",
+		"EcsServices",
+		"EfsMountTargets",
 		"ElasticIps",
+		"EmrClusters",
+		"Fleets",
 		"InternetGateways",
+		"Karpenter",
+		"LambdaFunctions",
 		"LoadBalancers",
+		"MqBrokers",
+		"MskClusters",
+		"MwaaEnvironments",
 		"NatGateways",
 		"NetworkAcls",
 		"NetworkInterfaces",
+		"OpenSearchDomains",
+		"RedshiftClusters",
+		"ReplicationInstances",
 		"Reservations",
 		"RouteTables",
+		"SageMakerDomains",
 		"SecurityGroups",
 		"Subnets",
+		"Volumes",
 		"VpcPeeringConnections",
 		"VpnGateways",
 	)
@@ -53,8 +141,22 @@ func run() error {
 	autoScalingTagKey := flag.String("autoscaling-tag-key", "", "AutoScaling tag key")
 	autoScalingTagValue := flag.String("autoscaling-tag-value", "owned", `AutoScaling tag value (default "owner")`)
 	clusterName := flag.String("cluster-name", "", "cluster name")
+	clusterOnly := flag.Bool("cluster-only", false, "delete only the cluster and its resources, not the VPC")
+	deleteEcsClusters := flag.Bool("delete-ecs-clusters", false, "delete ECS clusters left empty")
+	deleteEfsFileSystems := flag.Bool("delete-efs-file-systems", false, "delete EFS file systems owned by the cluster")
+	deleteLambdaFunctions := flag.Bool("delete-lambda-functions", false, "delete Lambda functions in the VPC instead of removing their VPC configuration")
+	deleteLaunchTemplates := flag.Bool("delete-launch-templates", false, "delete LaunchTemplates and LaunchConfigurations left unused by deleted AutoScalingGroups")
+	deleteSageMakerHomeEfsFileSystems := flag.Bool("delete-sagemaker-home-efs-file-systems", false, "delete the home EFS file systems of SageMaker domains with the domains")
 	flag.Var(excludeResources, "exclude", "resource types to exclude (default none)")
+	finalSnapshotPrefix := flag.String("final-snapshot-prefix", "aws-delete-vpc-final-", "prefix of the names of final snapshots of databases, caches, and Redshift clusters")
+	force := flag.Bool("force", false, "disable termination and deletion protection")
+	forceDeleteAutoScalingGroups := flag.Bool("force-delete-autoscaling-groups", false, "delete AutoScalingGroups without waiting for their Instances to terminate")
 	flag.Var(includeResources, "include", "resource types to include (default all)")
+	kubeconfig := flag.String("kubeconfig", "", "kubeconfig of the cluster to drain before deleting AWS resources")
+	kubernetesDrainTimeout := flag.Duration("kubernetes-drain-timeout", 10*time.Minute, "Kubernetes drain timeout")
+	lambdaNetworkInterfaceTimeout := flag.Duration("lambda-network-interface-timeout", 45*time.Minute, "Lambda NetworkInterface deletion timeout")
+	skipFinalSnapshots := flag.Bool("skip-final-snapshots", false, "delete databases, caches, and Redshift clusters without taking final snapshots")
+	snapshotVolumes := flag.Bool("snapshot-volumes", false, "snapshot Volumes before deleting them")
 	retryInterval := flag.Duration("retry-interval", 1*time.Minute, "Re-try interval")
 	tries := flag.Int("tries", 3, "tries")
 	vpcId := flag.String("vpc-id", "", "VPC ID")
@@ -108,6 +210,26 @@ func run() error {
 
 	resources := includeResources.subtract(excludeResources)
 
+	// Final snapshots are taken unless explicitly skipped.
+	if *skipFinalSnapshots {
+		*finalSnapshotPrefix = ""
+	} else if *finalSnapshotPrefix == "" {
+		return errors.New("-final-snapshot-prefix must not be empty, pass -skip-final-snapshots to skip final snapshots")
+	}
+
+	opts := &options{
+		deleteEcsClusters:                 *deleteEcsClusters,
+		deleteEfsFileSystems:              *deleteEfsFileSystems,
+		deleteLambdaFunctions:             *deleteLambdaFunctions,
+		deleteLaunchTemplates:             *deleteLaunchTemplates,
+		deleteSageMakerHomeEfsFileSystems: *deleteSageMakerHomeEfsFileSystems,
+		finalSnapshotPrefix:               *finalSnapshotPrefix,
+		force:                             *force,
+		forceDeleteAutoScalingGroups:      *forceDeleteAutoScalingGroups,
+		lambdaNetworkInterfaceTimeout:     *lambdaNetworkInterfaceTimeout,
+		snapshotVolumes:                   *snapshotVolumes,
+	}
+
 	// By default, use the tag k8s.io/cluster/$CLUSTER_NAME=owned to identify
 	// AutoScalingGroups.
 	//
@@ -134,6 +256,77 @@ func run() error {
 		return errors.New("VPC ID not set")
 	}
 
+	// Resources tagged as shared with the cluster may be used by other
+	// clusters, so must not be deleted. The VPC's shared Subnets,
+	// SecurityGroups, and RouteTables are skipped and the cluster's tags are
+	// removed from them. With -cluster-only all shared resources are left
+	// intact, and the cluster's tags are removed from them once the cluster's
+	// own resources have been deleted.
+	var sharedResourceIds []string
+	if *clusterName != "" {
+		sharedResourceIds, err = listSharedVpcResourceIds(ctx, clients.ec2, *clusterName, *vpcId)
+		if err != nil {
+			return err
+		}
+		log.Info().
+			Strs("resourceIds", sharedResourceIds).
+			Msg("listSharedVpcResourceIds")
+		opts.sharedResourceIds = newStringSet(sharedResourceIds...)
+	}
+
+	// Let the cluster's own controllers delete the AWS resources that they
+	// created, otherwise they will recreate them as they are deleted. Anything
+	// that they leave behind is deleted below. If the VPC has already been
+	// deleted then there is nothing left for them to delete.
+	if *kubeconfig != "" {
+		exists, err := vpcExists(ctx, clients.ec2, *vpcId)
+		if err != nil {
+			return err
+		}
+		if exists {
+			kubernetesClient, err := newKubernetesClientFromKubeconfig(*kubeconfig)
+			if err != nil {
+				return err
+			}
+			err = drainKubernetesCluster(ctx, kubernetesClient, *kubernetesDrainTimeout)
+			log.Err(err).
+				Str("kubeconfig", *kubeconfig).
+				Msg("drainKubernetesCluster")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if *clusterOnly {
+		if *clusterName == "" {
+			return errors.New("-cluster-only requires -cluster-name")
+		}
+
+		for try := 0; try < *tries; try++ {
+			if try != 0 {
+				log.Info().
+					Dur("duration", *retryInterval).
+					Msg("Sleep")
+				time.Sleep(*retryInterval)
+			}
+
+			err := deleteClusterDependencies(ctx, clients, *clusterName, cluster, *vpcId, resources, autoScalingFilters, opts)
+			log.Err(err).
+				Str("clusterName", *clusterName).
+				Str("vpcId", *vpcId).
+				Msg("deleteClusterDependencies")
+			if err == nil {
+				if len(sharedResourceIds) == 0 {
+					return nil
+				}
+				return untagSharedResources(ctx, clients.ec2, *clusterName, sharedResourceIds)
+			}
+		}
+
+		return errors.New("failed")
+	}
+
 	deleted, err := tryDeleteVpc(ctx, clients.ec2, *vpcId)
 	log.Err(err).
 		Bool("deleted", deleted).
@@ -161,7 +354,7 @@ func run() error {
 			time.Sleep(*retryInterval)
 		}
 
-		err := deleteVpcDependencies(ctx, clients, *clusterName, *vpcId, resources, autoScalingFilters)
+		err := deleteVpcDependencies(ctx, clients, *clusterName, *vpcId, resources, autoScalingFilters, opts)
 		log.Err(err).
 			Str("vpcId", *vpcId).
 			Msg("deleteVpcDependencies")
@@ -182,5 +375,12 @@ func run() error {
 		}
 	}
 
+	// Shared resources other than Subnets, SecurityGroups, and RouteTables,
+	// for example Instances of other clusters, prevent the VPC from being
+	// deleted.
+	if len(opts.sharedResourceIds) > 0 {
+		return fmt.Errorf("failed, VPC %s contains resources shared with cluster %q: %s", *vpcId, *clusterName, opts.sharedResourceIds)
+	}
+
 	return errors.New("failed")
 }
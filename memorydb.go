@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/memorydb"
+	"github.com/aws/aws-sdk-go-v2/service/memorydb/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteMemoryDBClusters deletes clusters and waits for them to be deleted. If
+// finalSnapshotPrefix is set then a final snapshot of each cluster is taken,
+// named with the prefix followed by its name.
+func deleteMemoryDBClusters(ctx context.Context, client *memorydb.Client, clusters []types.Cluster, finalSnapshotPrefix string) error {
+	var errs error
+	deletingClusterNames := newStringSet()
+	for _, cluster := range clusters {
+		if cluster.Name == nil {
+			continue
+		}
+		if aws.ToString(cluster.Status) == "deleting" {
+			deletingClusterNames[*cluster.Name] = struct{}{}
+			continue
+		}
+		input := memorydb.DeleteClusterInput{
+			ClusterName: cluster.Name,
+		}
+		if finalSnapshotPrefix != "" {
+			input.FinalSnapshotName = aws.String(finalSnapshotPrefix + *cluster.Name)
+		}
+		_, err := client.DeleteCluster(ctx, &input)
+		log.Err(err).
+			Str("ClusterName", *cluster.Name).
+			Str("FinalSnapshotName", aws.ToString(input.FinalSnapshotName)).
+			Msg("DeleteCluster")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingClusterNames[*cluster.Name] = struct{}{}
+		}
+	}
+	if len(deletingClusterNames) == 0 {
+		return errs
+	}
+
+	// Wait for the clusters to be deleted.
+	var remaining []string
+	err := poll(ctx, cacheClusterPollInterval, cacheClusterDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		clusters, err := listMemoryDBClusters(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, clusterName := range memoryDBClusterNames(clusters) {
+			if deletingClusterNames.contains(clusterName) {
+				remaining = append(remaining, clusterName)
+			}
+		}
+		log.Info().
+			Strs("ClusterNames", remaining).
+			Msg("DescribeClusters")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for MemoryDB clusters to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteMemoryDBClustersInVpc deletes the MemoryDB clusters in the VPC vpcId,
+// waits for them to be deleted, and then deletes the VPC's MemoryDB subnet
+// groups.
+func deleteMemoryDBClustersInVpc(ctx context.Context, client *memorydb.Client, vpcId, finalSnapshotPrefix string) error {
+	subnetGroups, err := listMemoryDBSubnetGroups(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listMemoryDBSubnetGroups")
+		return err
+	}
+	log.Info().
+		Strs("subnetGroupNames", memoryDBSubnetGroupNames(subnetGroups)).
+		Msg("listMemoryDBSubnetGroups")
+	if len(subnetGroups) == 0 {
+		return nil
+	}
+
+	clusters, err := listMemoryDBClusters(ctx, client, newStringSet(memoryDBSubnetGroupNames(subnetGroups)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listMemoryDBClusters")
+		return err
+	}
+	log.Info().
+		Strs("clusterNames", memoryDBClusterNames(clusters)).
+		Msg("listMemoryDBClusters")
+	if len(clusters) > 0 {
+		err := deleteMemoryDBClusters(ctx, client, clusters, finalSnapshotPrefix)
+		log.Err(err).
+			Strs("clusterNames", memoryDBClusterNames(clusters)).
+			Msg("deleteMemoryDBClusters")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = deleteMemoryDBSubnetGroups(ctx, client, subnetGroups)
+	log.Err(err).
+		Strs("subnetGroupNames", memoryDBSubnetGroupNames(subnetGroups)).
+		Msg("deleteMemoryDBSubnetGroups")
+	return err
+}
+
+// deleteMemoryDBSubnetGroups deletes subnetGroups, except for the default
+// subnet group, which cannot be deleted.
+func deleteMemoryDBSubnetGroups(ctx context.Context, client *memorydb.Client, subnetGroups []types.SubnetGroup) (errs error) {
+	for _, subnetGroup := range subnetGroups {
+		if subnetGroup.Name == nil || *subnetGroup.Name == "default" {
+			continue
+		}
+		_, err := client.DeleteSubnetGroup(ctx, &memorydb.DeleteSubnetGroupInput{
+			SubnetGroupName: subnetGroup.Name,
+		})
+		log.Err(err).
+			Str("SubnetGroupName", *subnetGroup.Name).
+			Msg("DeleteSubnetGroup")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// listMemoryDBClusters returns the MemoryDB clusters whose subnet group is one
+// of subnetGroupNames, or all MemoryDB clusters if subnetGroupNames is nil.
+func listMemoryDBClusters(ctx context.Context, client *memorydb.Client, subnetGroupNames stringSet) ([]types.Cluster, error) {
+	input := memorydb.DescribeClustersInput{}
+	var clusters []types.Cluster
+	for {
+		output, err := client.DescribeClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range output.Clusters {
+			if subnetGroupNames == nil || (cluster.SubnetGroupName != nil && subnetGroupNames.contains(*cluster.SubnetGroupName)) {
+				clusters = append(clusters, cluster)
+			}
+		}
+		if output.NextToken == nil {
+			return clusters, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listMemoryDBSubnetGroups returns the MemoryDB subnet groups in the VPC vpcId.
+func listMemoryDBSubnetGroups(ctx context.Context, client *memorydb.Client, vpcId string) ([]types.SubnetGroup, error) {
+	input := memorydb.DescribeSubnetGroupsInput{}
+	var subnetGroups []types.SubnetGroup
+	for {
+		output, err := client.DescribeSubnetGroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, subnetGroup := range output.SubnetGroups {
+			if aws.ToString(subnetGroup.VpcId) == vpcId {
+				subnetGroups = append(subnetGroups, subnetGroup)
+			}
+		}
+		if output.NextToken == nil {
+			return subnetGroups, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func memoryDBClusterNames(clusters []types.Cluster) []string {
+	clusterNames := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.Name != nil {
+			clusterNames = append(clusterNames, *cluster.Name)
+		}
+	}
+	return clusterNames
+}
+
+func memoryDBSubnetGroupNames(subnetGroups []types.SubnetGroup) []string {
+	subnetGroupNames := make([]string, 0, len(subnetGroups))
+	for _, subnetGroup := range subnetGroups {
+		if subnetGroup.Name != nil {
+			subnetGroupNames = append(subnetGroupNames, *subnetGroup.Name)
+		}
+	}
+	return subnetGroupNames
+}
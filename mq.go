@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/mq"
+	"github.com/aws/aws-sdk-go-v2/service/mq/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteMqBrokers deletes brokers and waits for them to be deleted.
+func deleteMqBrokers(ctx context.Context, client *mq.Client, brokers []*mq.DescribeBrokerOutput) error {
+	var errs error
+	deletingBrokerIds := newStringSet()
+	for _, broker := range brokers {
+		if broker.BrokerId == nil {
+			continue
+		}
+		if broker.BrokerState == types.BrokerStateDeletionInProgress {
+			deletingBrokerIds[*broker.BrokerId] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteBroker(ctx, &mq.DeleteBrokerInput{
+			BrokerId: broker.BrokerId,
+		})
+		log.Err(err).
+			Str("BrokerId", *broker.BrokerId).
+			Msg("DeleteBroker")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingBrokerIds[*broker.BrokerId] = struct{}{}
+		}
+	}
+	if len(deletingBrokerIds) == 0 {
+		return errs
+	}
+
+	// Wait for the brokers to be deleted.
+	var remaining []string
+	err := poll(ctx, mqBrokerPollInterval, mqBrokerDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		brokerIds, err := listMqBrokerIds(ctx, client)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, brokerId := range brokerIds {
+			if deletingBrokerIds.contains(brokerId) {
+				remaining = append(remaining, brokerId)
+			}
+		}
+		log.Info().
+			Strs("BrokerIds", remaining).
+			Msg("ListBrokers")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for Amazon MQ brokers to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteMqBrokersInVpc deletes the Amazon MQ brokers with subnets in the VPC
+// vpcId, waits for them to be deleted, and then waits for Amazon MQ to delete
+// their requester-managed NetworkInterfaces.
+func deleteMqBrokersInVpc(ctx context.Context, client *mq.Client, ec2Client *ec2.Client, vpcId string) error {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+
+	brokers, err := listMqBrokers(ctx, client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listMqBrokers")
+		return err
+	}
+	log.Info().
+		Strs("brokerIds", mqBrokerIds(brokers)).
+		Msg("listMqBrokers")
+	if len(brokers) == 0 {
+		return nil
+	}
+
+	err = deleteMqBrokers(ctx, client, brokers)
+	log.Err(err).
+		Strs("brokerIds", mqBrokerIds(brokers)).
+		Msg("deleteMqBrokers")
+	if err != nil {
+		return err
+	}
+
+	// Wait for the requester-managed NetworkInterfaces in the brokers'
+	// subnets and security groups to be deleted.
+	var brokerSubnetIds, brokerSecurityGroupIds []string
+	for _, broker := range brokers {
+		brokerSubnetIds = append(brokerSubnetIds, broker.SubnetIds...)
+		brokerSecurityGroupIds = append(brokerSecurityGroupIds, broker.SecurityGroups...)
+	}
+	filters := append(ec2VpcFilter(vpcId),
+		ec2types.Filter{
+			Name:   aws.String("requester-managed"),
+			Values: []string{"true"},
+		},
+		ec2types.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: brokerSubnetIds,
+		},
+	)
+	if len(brokerSecurityGroupIds) > 0 {
+		filters = append(filters, ec2types.Filter{
+			Name:   aws.String("group-id"),
+			Values: brokerSecurityGroupIds,
+		})
+	}
+	err = waitForNetworkInterfacesDeleted(ctx, ec2Client, filters, mqBrokerDeletedWaiterMaxDuration)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("waitForNetworkInterfacesDeleted")
+	return err
+}
+
+func listMqBrokerIds(ctx context.Context, client *mq.Client) ([]string, error) {
+	input := mq.ListBrokersInput{}
+	var brokerIds []string
+	for {
+		output, err := client.ListBrokers(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, brokerSummary := range output.BrokerSummaries {
+			if brokerSummary.BrokerId != nil {
+				brokerIds = append(brokerIds, *brokerSummary.BrokerId)
+			}
+		}
+		if output.NextToken == nil {
+			return brokerIds, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// isMqBrokerInSubnets returns whether broker has a subnet in subnetIds.
+func isMqBrokerInSubnets(broker *mq.DescribeBrokerOutput, subnetIds stringSet) bool {
+	for _, subnetId := range broker.SubnetIds {
+		if subnetIds.contains(subnetId) {
+			return true
+		}
+	}
+	return false
+}
+
+// listMqBrokers returns the Amazon MQ brokers with a subnet in subnetIds.
+// Brokers that are deleted while they are being listed are ignored.
+func listMqBrokers(ctx context.Context, client *mq.Client, subnetIds stringSet) ([]*mq.DescribeBrokerOutput, error) {
+	brokerIds, err := listMqBrokerIds(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	var brokers []*mq.DescribeBrokerOutput
+	for _, brokerId := range brokerIds {
+		broker, err := client.DescribeBroker(ctx, &mq.DescribeBrokerInput{
+			BrokerId: aws.String(brokerId),
+		})
+		if err != nil {
+			var notFoundException *types.NotFoundException
+			if errors.As(err, &notFoundException) {
+				continue
+			}
+			return nil, err
+		}
+		if isMqBrokerInSubnets(broker, subnetIds) {
+			brokers = append(brokers, broker)
+		}
+	}
+	return brokers, nil
+}
+
+func mqBrokerIds(brokers []*mq.DescribeBrokerOutput) []string {
+	brokerIds := make([]string, 0, len(brokers))
+	for _, broker := range brokers {
+		if broker.BrokerId != nil {
+			brokerIds = append(brokerIds, *broker.BrokerId)
+		}
+	}
+	return brokerIds
+}
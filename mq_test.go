@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/mq"
+)
+
+func TestIsMqBrokerInSubnets(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		broker    *mq.DescribeBrokerOutput
+		subnetIds stringSet
+		expected  bool
+	}{
+		{
+			name:      "no_subnets",
+			broker:    &mq.DescribeBrokerOutput{},
+			subnetIds: newStringSet("subnet-1"),
+		},
+		{
+			name: "nil_subnet_ids",
+			broker: &mq.DescribeBrokerOutput{
+				SubnetIds: []string{"subnet-1"},
+			},
+		},
+		{
+			name: "no_match",
+			broker: &mq.DescribeBrokerOutput{
+				SubnetIds: []string{"subnet-1"},
+			},
+			subnetIds: newStringSet("subnet-2"),
+		},
+		{
+			name: "match",
+			broker: &mq.DescribeBrokerOutput{
+				SubnetIds: []string{"subnet-1", "subnet-2"},
+			},
+			subnetIds: newStringSet("subnet-2"),
+			expected:  true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := isMqBrokerInSubnets(tc.broker, tc.subnetIds); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
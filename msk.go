@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/kafka"
+	"github.com/aws/aws-sdk-go-v2/service/kafka/types"
+	"github.com/aws/aws-sdk-go-v2/service/kafkaconnect"
+	kafkaconnecttypes "github.com/aws/aws-sdk-go-v2/service/kafkaconnect/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteMskClusters deletes clusters.
+func deleteMskClusters(ctx context.Context, client *kafka.Client, clusters []types.Cluster) (errs error) {
+	for _, cluster := range clusters {
+		if cluster.ClusterArn == nil || cluster.State == types.ClusterStateDeleting {
+			continue
+		}
+		_, err := client.DeleteCluster(ctx, &kafka.DeleteClusterInput{
+			ClusterArn:     cluster.ClusterArn,
+			CurrentVersion: cluster.CurrentVersion,
+		})
+		log.Err(err).
+			Str("ClusterArn", *cluster.ClusterArn).
+			Msg("DeleteCluster")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteMskConnectors deletes connectors.
+func deleteMskConnectors(ctx context.Context, client *kafkaconnect.Client, connectors []kafkaconnecttypes.ConnectorSummary) (errs error) {
+	for _, connector := range connectors {
+		if connector.ConnectorArn == nil || connector.ConnectorState == kafkaconnecttypes.ConnectorStateDeleting {
+			continue
+		}
+		_, err := client.DeleteConnector(ctx, &kafkaconnect.DeleteConnectorInput{
+			ConnectorArn:   connector.ConnectorArn,
+			CurrentVersion: connector.CurrentVersion,
+		})
+		log.Err(err).
+			Str("ConnectorArn", *connector.ConnectorArn).
+			Msg("DeleteConnector")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteMskInVpc deletes the MSK Connect connectors and the provisioned and
+// serverless MSK clusters with client subnets in the VPC vpcId, and then waits
+// for MSK to delete their requester-managed NetworkInterfaces. It accumulates
+// errors.
+func deleteMskInVpc(ctx context.Context, client *kafka.Client, connectClient *kafkaconnect.Client, ec2Client *ec2.Client, vpcId string) (errs error) {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+	vpcSubnetIds := newStringSet(subnetIds(subnets)...)
+
+	// The subnets and security groups of the deleted connectors and clusters,
+	// which identify their NetworkInterfaces.
+	var deletedSubnetIds, deletedSecurityGroupIds []string
+
+	connectors, err := listMskConnectors(ctx, connectClient, vpcSubnetIds)
+	if err != nil {
+		log.Err(err).
+			Msg("listMskConnectors")
+		return err
+	}
+	log.Info().
+		Strs("connectorArns", mskConnectorArns(connectors)).
+		Msg("listMskConnectors")
+	if len(connectors) > 0 {
+		err := deleteMskConnectors(ctx, connectClient, connectors)
+		log.Err(err).
+			Strs("connectorArns", mskConnectorArns(connectors)).
+			Msg("deleteMskConnectors")
+		errs = multierr.Append(errs, err)
+		for _, connector := range connectors {
+			subnetIds, securityGroupIds := mskConnectorSubnetAndSecurityGroupIds(connector)
+			deletedSubnetIds = append(deletedSubnetIds, subnetIds...)
+			deletedSecurityGroupIds = append(deletedSecurityGroupIds, securityGroupIds...)
+		}
+	}
+
+	clusters, err := listMskClusters(ctx, client, vpcSubnetIds)
+	if err != nil {
+		log.Err(err).
+			Msg("listMskClusters")
+		return multierr.Append(errs, err)
+	}
+	log.Info().
+		Strs("clusterArns", mskClusterArns(clusters)).
+		Msg("listMskClusters")
+	if len(clusters) > 0 {
+		err := deleteMskClusters(ctx, client, clusters)
+		log.Err(err).
+			Strs("clusterArns", mskClusterArns(clusters)).
+			Msg("deleteMskClusters")
+		errs = multierr.Append(errs, err)
+		for _, cluster := range clusters {
+			subnetIds, securityGroupIds := mskClusterSubnetAndSecurityGroupIds(cluster)
+			deletedSubnetIds = append(deletedSubnetIds, subnetIds...)
+			deletedSecurityGroupIds = append(deletedSecurityGroupIds, securityGroupIds...)
+		}
+	}
+	if errs != nil || len(deletedSubnetIds) == 0 {
+		return
+	}
+
+	// Wait for the requester-managed NetworkInterfaces in the connectors' and
+	// clusters' subnets and security groups to be deleted.
+	filters := []ec2types.Filter{
+		{
+			Name:   aws.String("requester-managed"),
+			Values: []string{"true"},
+		},
+		{
+			Name:   aws.String("subnet-id"),
+			Values: deletedSubnetIds,
+		},
+	}
+	if len(deletedSecurityGroupIds) > 0 {
+		filters = append(filters, ec2types.Filter{
+			Name:   aws.String("group-id"),
+			Values: deletedSecurityGroupIds,
+		})
+	}
+	err = waitForNetworkInterfacesDeleted(ctx, ec2Client, append(ec2VpcFilter(vpcId), filters...), mskClusterDeletedWaiterMaxDuration)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("waitForNetworkInterfacesDeleted")
+	return multierr.Append(errs, err)
+}
+
+// filterMskClustersBySubnetIds returns the clusters with a client subnet in
+// subnetIds.
+func filterMskClustersBySubnetIds(clusters []types.Cluster, subnetIds stringSet) []types.Cluster {
+	var filteredClusters []types.Cluster
+CLUSTER:
+	for _, cluster := range clusters {
+		clusterSubnetIds, _ := mskClusterSubnetAndSecurityGroupIds(cluster)
+		for _, subnetId := range clusterSubnetIds {
+			if subnetIds.contains(subnetId) {
+				filteredClusters = append(filteredClusters, cluster)
+				continue CLUSTER
+			}
+		}
+	}
+	return filteredClusters
+}
+
+// filterMskConnectorsBySubnetIds returns the connectors with a subnet in
+// subnetIds.
+func filterMskConnectorsBySubnetIds(connectors []kafkaconnecttypes.ConnectorSummary, subnetIds stringSet) []kafkaconnecttypes.ConnectorSummary {
+	var filteredConnectors []kafkaconnecttypes.ConnectorSummary
+CONNECTOR:
+	for _, connector := range connectors {
+		connectorSubnetIds, _ := mskConnectorSubnetAndSecurityGroupIds(connector)
+		for _, subnetId := range connectorSubnetIds {
+			if subnetIds.contains(subnetId) {
+				filteredConnectors = append(filteredConnectors, connector)
+				continue CONNECTOR
+			}
+		}
+	}
+	return filteredConnectors
+}
+
+// listMskClusters returns the provisioned and serverless MSK clusters with a
+// client subnet in subnetIds.
+func listMskClusters(ctx context.Context, client *kafka.Client, subnetIds stringSet) ([]types.Cluster, error) {
+	input := kafka.ListClustersV2Input{}
+	var clusters []types.Cluster
+	for {
+		output, err := client.ListClustersV2(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, filterMskClustersBySubnetIds(output.ClusterInfoList, subnetIds)...)
+		if output.NextToken == nil {
+			return clusters, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listMskConnectors returns the MSK Connect connectors with a subnet in
+// subnetIds.
+func listMskConnectors(ctx context.Context, client *kafkaconnect.Client, subnetIds stringSet) ([]kafkaconnecttypes.ConnectorSummary, error) {
+	input := kafkaconnect.ListConnectorsInput{}
+	var connectors []kafkaconnecttypes.ConnectorSummary
+	for {
+		output, err := client.ListConnectors(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		connectors = append(connectors, filterMskConnectorsBySubnetIds(output.Connectors, subnetIds)...)
+		if output.NextToken == nil {
+			return connectors, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func mskClusterArns(clusters []types.Cluster) []string {
+	clusterArns := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.ClusterArn != nil {
+			clusterArns = append(clusterArns, *cluster.ClusterArn)
+		}
+	}
+	return clusterArns
+}
+
+// mskClusterSubnetAndSecurityGroupIds returns the client subnets and security
+// groups of a provisioned or serverless cluster.
+func mskClusterSubnetAndSecurityGroupIds(cluster types.Cluster) (subnetIds, securityGroupIds []string) {
+	if cluster.Provisioned != nil && cluster.Provisioned.BrokerNodeGroupInfo != nil {
+		subnetIds = append(subnetIds, cluster.Provisioned.BrokerNodeGroupInfo.ClientSubnets...)
+		securityGroupIds = append(securityGroupIds, cluster.Provisioned.BrokerNodeGroupInfo.SecurityGroups...)
+	}
+	if cluster.Serverless != nil {
+		for _, vpcConfig := range cluster.Serverless.VpcConfigs {
+			subnetIds = append(subnetIds, vpcConfig.SubnetIds...)
+			securityGroupIds = append(securityGroupIds, vpcConfig.SecurityGroupIds...)
+		}
+	}
+	return
+}
+
+func mskConnectorArns(connectors []kafkaconnecttypes.ConnectorSummary) []string {
+	connectorArns := make([]string, 0, len(connectors))
+	for _, connector := range connectors {
+		if connector.ConnectorArn != nil {
+			connectorArns = append(connectorArns, *connector.ConnectorArn)
+		}
+	}
+	return connectorArns
+}
+
+// mskConnectorSubnetAndSecurityGroupIds returns the subnets and security groups
+// of a connector.
+func mskConnectorSubnetAndSecurityGroupIds(connector kafkaconnecttypes.ConnectorSummary) (subnetIds, securityGroupIds []string) {
+	if connector.KafkaCluster == nil || connector.KafkaCluster.ApacheKafkaCluster == nil || connector.KafkaCluster.ApacheKafkaCluster.Vpc == nil {
+		return nil, nil
+	}
+	vpc := connector.KafkaCluster.ApacheKafkaCluster.Vpc
+	return vpc.Subnets, vpc.SecurityGroups
+}
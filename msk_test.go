@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kafka/types"
+	kafkaconnecttypes "github.com/aws/aws-sdk-go-v2/service/kafkaconnect/types"
+)
+
+func TestFilterMskClustersBySubnetIds(t *testing.T) {
+	provisioned := types.Cluster{
+		ClusterArn: aws.String("provisioned"),
+		Provisioned: &types.Provisioned{
+			BrokerNodeGroupInfo: &types.BrokerNodeGroupInfo{
+				ClientSubnets:  []string{"subnet-1", "subnet-2"},
+				SecurityGroups: []string{"sg-1"},
+			},
+		},
+	}
+	provisionedWithoutBrokerNodeGroupInfo := types.Cluster{
+		ClusterArn:  aws.String("provisioned-without-broker-node-group-info"),
+		Provisioned: &types.Provisioned{},
+	}
+	serverless := types.Cluster{
+		ClusterArn: aws.String("serverless"),
+		Serverless: &types.Serverless{
+			VpcConfigs: []types.VpcConfig{
+				{
+					SubnetIds: []string{"subnet-3"},
+				},
+				{
+					SubnetIds:        []string{"subnet-4"},
+					SecurityGroupIds: []string{"sg-1"},
+				},
+			},
+		},
+	}
+	clusters := []types.Cluster{provisioned, provisionedWithoutBrokerNodeGroupInfo, serverless}
+
+	for _, tc := range []struct {
+		name                string
+		subnetIds           stringSet
+		expectedClusterArns []string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:      "no_match",
+			subnetIds: newStringSet("subnet-5"),
+		},
+		{
+			name:                "provisioned",
+			subnetIds:           newStringSet("subnet-2"),
+			expectedClusterArns: []string{"provisioned"},
+		},
+		{
+			name:                "serverless_second_vpc_config",
+			subnetIds:           newStringSet("subnet-4"),
+			expectedClusterArns: []string{"serverless"},
+		},
+		{
+			name:                "all",
+			subnetIds:           newStringSet("subnet-1", "subnet-2", "subnet-3", "subnet-4"),
+			expectedClusterArns: []string{"provisioned", "serverless"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := filterMskClustersBySubnetIds(clusters, tc.subnetIds)
+			assertStrings(t, tc.expectedClusterArns, mskClusterArns(actual))
+		})
+	}
+}
+
+func TestFilterMskConnectorsBySubnetIds(t *testing.T) {
+	connectors := []kafkaconnecttypes.ConnectorSummary{
+		{
+			ConnectorArn: aws.String("no-kafka-cluster"),
+		},
+		{
+			ConnectorArn: aws.String("no-vpc"),
+			KafkaCluster: &kafkaconnecttypes.KafkaClusterDescription{
+				ApacheKafkaCluster: &kafkaconnecttypes.ApacheKafkaClusterDescription{},
+			},
+		},
+		{
+			ConnectorArn: aws.String("vpc"),
+			KafkaCluster: &kafkaconnecttypes.KafkaClusterDescription{
+				ApacheKafkaCluster: &kafkaconnecttypes.ApacheKafkaClusterDescription{
+					Vpc: &kafkaconnecttypes.VpcDescription{
+						Subnets:        []string{"subnet-1", "subnet-2"},
+						SecurityGroups: []string{"sg-1"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name                  string
+		subnetIds             stringSet
+		expectedConnectorArns []string
+	}{
+		{
+			name: "nil",
+		},
+		{
+			name:      "no_match",
+			subnetIds: newStringSet("subnet-3"),
+		},
+		{
+			name:                  "match",
+			subnetIds:             newStringSet("subnet-2", "subnet-3"),
+			expectedConnectorArns: []string{"vpc"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := filterMskConnectorsBySubnetIds(connectors, tc.subnetIds)
+			assertStrings(t, tc.expectedConnectorArns, mskConnectorArns(actual))
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/mwaa"
+	"github.com/aws/aws-sdk-go-v2/service/mwaa/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteMwaaEnvironments deletes environments and waits for them to be
+// deleted.
+func deleteMwaaEnvironments(ctx context.Context, client *mwaa.Client, environments []*types.Environment) error {
+	var errs error
+	deletingEnvironmentNames := newStringSet()
+	for _, environment := range environments {
+		if environment.Name == nil {
+			continue
+		}
+		if environment.Status == types.EnvironmentStatusDeleting {
+			deletingEnvironmentNames[*environment.Name] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteEnvironment(ctx, &mwaa.DeleteEnvironmentInput{
+			Name: environment.Name,
+		})
+		log.Err(err).
+			Str("Name", *environment.Name).
+			Msg("DeleteEnvironment")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingEnvironmentNames[*environment.Name] = struct{}{}
+		}
+	}
+	if len(deletingEnvironmentNames) == 0 {
+		return errs
+	}
+
+	// Wait for the environments to be deleted.
+	var remaining []string
+	err := poll(ctx, mwaaEnvironmentPollInterval, mwaaEnvironmentDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		environments, err := listMwaaEnvironments(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, environmentName := range mwaaEnvironmentNames(environments) {
+			if deletingEnvironmentNames.contains(environmentName) {
+				remaining = append(remaining, environmentName)
+			}
+		}
+		log.Info().
+			Strs("Names", remaining).
+			Msg("ListEnvironments")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for MWAA environments to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteMwaaEnvironmentsInVpc deletes the Amazon Managed Workflows for Apache
+// Airflow (MWAA) environments with subnets in the VPC vpcId, waits for them to
+// be deleted, and then records their requester-managed NetworkInterfaces in
+// opts, to be waited for once all other services have been deleted.
+func deleteMwaaEnvironmentsInVpc(ctx context.Context, client *mwaa.Client, ec2Client *ec2.Client, vpcId string, opts *options) error {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+
+	environments, err := listMwaaEnvironments(ctx, client, newStringSet(subnetIds(subnets)...))
+	if err != nil {
+		log.Err(err).
+			Msg("listMwaaEnvironments")
+		return err
+	}
+	log.Info().
+		Strs("environmentNames", mwaaEnvironmentNames(environments)).
+		Msg("listMwaaEnvironments")
+	if len(environments) == 0 {
+		return nil
+	}
+
+	err = deleteMwaaEnvironments(ctx, client, environments)
+	log.Err(err).
+		Strs("environmentNames", mwaaEnvironmentNames(environments)).
+		Msg("deleteMwaaEnvironments")
+	if err != nil {
+		return err
+	}
+
+	// Record the requester-managed NetworkInterfaces in the environments'
+	// subnets and security groups.
+	var environmentSubnetIds, environmentSecurityGroupIds []string
+	for _, environment := range environments {
+		environmentSubnetIds = append(environmentSubnetIds, environment.NetworkConfiguration.SubnetIds...)
+		environmentSecurityGroupIds = append(environmentSecurityGroupIds, environment.NetworkConfiguration.SecurityGroupIds...)
+	}
+	filters := append(ec2VpcFilter(vpcId),
+		ec2types.Filter{
+			Name:   aws.String("requester-managed"),
+			Values: []string{"true"},
+		},
+		ec2types.Filter{
+			Name:   aws.String("subnet-id"),
+			Values: environmentSubnetIds,
+		},
+	)
+	if len(environmentSecurityGroupIds) > 0 {
+		filters = append(filters, ec2types.Filter{
+			Name:   aws.String("group-id"),
+			Values: environmentSecurityGroupIds,
+		})
+	}
+	err = addPendingNetworkInterfaces(ctx, ec2Client, filters, mwaaEnvironmentDeletedWaiterMaxDuration, opts)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("addPendingNetworkInterfaces")
+	return err
+}
+
+// listMwaaEnvironments returns the MWAA environments that have not been
+// deleted with a subnet in subnetIds, or all environments that have not been
+// deleted if subnetIds is nil.
+func listMwaaEnvironments(ctx context.Context, client *mwaa.Client, subnetIds stringSet) ([]*types.Environment, error) {
+	input := mwaa.ListEnvironmentsInput{}
+	var environments []*types.Environment
+	for {
+		output, err := client.ListEnvironments(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	ENVIRONMENT:
+		for _, environmentName := range output.Environments {
+			getEnvironmentOutput, err := client.GetEnvironment(ctx, &mwaa.GetEnvironmentInput{
+				Name: aws.String(environmentName),
+			})
+			if err != nil {
+				var resourceNotFoundException *types.ResourceNotFoundException
+				if errors.As(err, &resourceNotFoundException) {
+					continue
+				}
+				return nil, err
+			}
+			environment := getEnvironmentOutput.Environment
+			if environment == nil || environment.Status == types.EnvironmentStatusDeleted {
+				continue
+			}
+			if subnetIds == nil {
+				environments = append(environments, environment)
+				continue
+			}
+			if environment.NetworkConfiguration == nil {
+				continue
+			}
+			for _, subnetId := range environment.NetworkConfiguration.SubnetIds {
+				if subnetIds.contains(subnetId) {
+					environments = append(environments, environment)
+					continue ENVIRONMENT
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return environments, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func mwaaEnvironmentNames(environments []*types.Environment) []string {
+	environmentNames := make([]string, 0, len(environments))
+	for _, environment := range environments {
+		if environment.Name != nil {
+			environmentNames = append(environmentNames, *environment.Name)
+		}
+	}
+	return environmentNames
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/rs/zerolog/log"
@@ -54,9 +58,9 @@ func deleteNetworkInterfaces(ctx context.Context, client *ec2.Client, networkInt
 	return
 }
 
-func listNetworkInterfaces(ctx context.Context, client *ec2.Client, vpcId string) ([]types.NetworkInterface, error) {
+func listNetworkInterfaces(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.NetworkInterface, error) {
 	input := ec2.DescribeNetworkInterfacesInput{
-		Filters: ec2VpcFilter(vpcId),
+		Filters: filters,
 	}
 	var networkInterfaces []types.NetworkInterface
 	for {
@@ -72,6 +76,65 @@ func listNetworkInterfaces(ctx context.Context, client *ec2.Client, vpcId string
 	}
 }
 
+// unassignNetworkInterfaceAddresses unassigns networkInterface's secondary
+// private IP addresses, IPv6 addresses, and delegated prefixes.
+func unassignNetworkInterfaceAddresses(ctx context.Context, client *ec2.Client, networkInterface types.NetworkInterface) (errs error) {
+	var ipv4Prefixes []string
+	for _, ipv4Prefix := range networkInterface.Ipv4Prefixes {
+		if ipv4Prefix.Ipv4Prefix != nil {
+			ipv4Prefixes = append(ipv4Prefixes, *ipv4Prefix.Ipv4Prefix)
+		}
+	}
+	var secondaryPrivateIpAddresses []string
+	for _, privateIpAddress := range networkInterface.PrivateIpAddresses {
+		if privateIpAddress.PrivateIpAddress == nil || (privateIpAddress.Primary != nil && *privateIpAddress.Primary) {
+			continue
+		}
+		secondaryPrivateIpAddresses = append(secondaryPrivateIpAddresses, *privateIpAddress.PrivateIpAddress)
+	}
+	if len(ipv4Prefixes) > 0 || len(secondaryPrivateIpAddresses) > 0 {
+		_, err := client.UnassignPrivateIpAddresses(ctx, &ec2.UnassignPrivateIpAddressesInput{
+			NetworkInterfaceId: networkInterface.NetworkInterfaceId,
+			Ipv4Prefixes:       ipv4Prefixes,
+			PrivateIpAddresses: secondaryPrivateIpAddresses,
+		})
+		log.Err(err).
+			Str("NetworkInterfaceId", *networkInterface.NetworkInterfaceId).
+			Strs("Ipv4Prefixes", ipv4Prefixes).
+			Strs("PrivateIpAddresses", secondaryPrivateIpAddresses).
+			Msg("UnassignPrivateIpAddresses")
+		errs = multierr.Append(errs, err)
+	}
+
+	var ipv6Prefixes []string
+	for _, ipv6Prefix := range networkInterface.Ipv6Prefixes {
+		if ipv6Prefix.Ipv6Prefix != nil {
+			ipv6Prefixes = append(ipv6Prefixes, *ipv6Prefix.Ipv6Prefix)
+		}
+	}
+	var ipv6Addresses []string
+	for _, ipv6Address := range networkInterface.Ipv6Addresses {
+		if ipv6Address.Ipv6Address != nil {
+			ipv6Addresses = append(ipv6Addresses, *ipv6Address.Ipv6Address)
+		}
+	}
+	if len(ipv6Prefixes) > 0 || len(ipv6Addresses) > 0 {
+		_, err := client.UnassignIpv6Addresses(ctx, &ec2.UnassignIpv6AddressesInput{
+			NetworkInterfaceId: networkInterface.NetworkInterfaceId,
+			Ipv6Addresses:      ipv6Addresses,
+			Ipv6Prefixes:       ipv6Prefixes,
+		})
+		log.Err(err).
+			Str("NetworkInterfaceId", *networkInterface.NetworkInterfaceId).
+			Strs("Ipv6Addresses", ipv6Addresses).
+			Strs("Ipv6Prefixes", ipv6Prefixes).
+			Msg("UnassignIpv6Addresses")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
 func networkInterfaceIds(networkInterfaces []types.NetworkInterface) []string {
 	networkInterfaceIds := make([]string, 0, len(networkInterfaces))
 	for _, networkInterface := range networkInterfaces {
@@ -81,3 +144,72 @@ func networkInterfaceIds(networkInterfaces []types.NetworkInterface) []string {
 	}
 	return networkInterfaceIds
 }
+
+// addPendingNetworkInterfaces records the NetworkInterfaces matching filters
+// in opts so that waitForPendingNetworkInterfacesDeleted waits, for up to
+// timeout, for them to be deleted.
+func addPendingNetworkInterfaces(ctx context.Context, client *ec2.Client, filters []types.Filter, timeout time.Duration, opts *options) error {
+	networkInterfaces, err := listNetworkInterfaces(ctx, client, filters)
+	if err != nil {
+		return err
+	}
+	if len(networkInterfaces) == 0 {
+		return nil
+	}
+	if opts.pendingNetworkInterfaceIds == nil {
+		opts.pendingNetworkInterfaceIds = newStringSet()
+	}
+	for _, networkInterfaceId := range networkInterfaceIds(networkInterfaces) {
+		opts.pendingNetworkInterfaceIds[networkInterfaceId] = struct{}{}
+	}
+	if timeout > opts.pendingNetworkInterfaceTimeout {
+		opts.pendingNetworkInterfaceTimeout = timeout
+	}
+	return nil
+}
+
+// waitForNetworkInterfacesDeleted waits, for up to timeout, until there are
+// no NetworkInterfaces matching filters. It is used to wait for services to
+// release the requester-managed NetworkInterfaces that they create in the VPC,
+// which cannot be deleted directly.
+func waitForNetworkInterfacesDeleted(ctx context.Context, client *ec2.Client, filters []types.Filter, timeout time.Duration) error {
+	var remaining []string
+	err := poll(ctx, networkInterfacePollInterval, timeout, func(ctx context.Context) (bool, error) {
+		networkInterfaces, err := listNetworkInterfaces(ctx, client, filters)
+		if err != nil {
+			return false, err
+		}
+		remaining = networkInterfaceIds(networkInterfaces)
+		log.Info().
+			Strs("networkInterfaceIds", remaining).
+			Msg("listNetworkInterfaces")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		return fmt.Errorf("timed out waiting for NetworkInterfaces to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return err
+}
+
+// waitForPendingNetworkInterfacesDeleted waits, for up to
+// opts.pendingNetworkInterfaceTimeout, for the NetworkInterfaces recorded by
+// addPendingNetworkInterfaces to be deleted. They are forgotten once they have
+// been deleted, otherwise they are waited for again in the next try.
+func waitForPendingNetworkInterfacesDeleted(ctx context.Context, client *ec2.Client, opts *options) error {
+	pendingNetworkInterfaceIds := make([]string, 0, len(opts.pendingNetworkInterfaceIds))
+	for networkInterfaceId := range opts.pendingNetworkInterfaceIds {
+		pendingNetworkInterfaceIds = append(pendingNetworkInterfaceIds, networkInterfaceId)
+	}
+	err := waitForNetworkInterfacesDeleted(ctx, client, []types.Filter{
+		{
+			Name:   aws.String("network-interface-id"),
+			Values: pendingNetworkInterfaceIds,
+		},
+	}, opts.pendingNetworkInterfaceTimeout)
+	if err != nil {
+		return err
+	}
+	opts.pendingNetworkInterfaceIds = nil
+	opts.pendingNetworkInterfaceTimeout = 0
+	return nil
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/rs/zerolog/log"
 )
 
 func deleteClusterNodeGroups(ctx context.Context, client *eks.Client, cluster *types.Cluster) error {
@@ -16,11 +19,33 @@ func deleteClusterNodeGroups(ctx context.Context, client *eks.Client, cluster *t
 			ClusterName:   cluster.Name,
 			NodegroupName: &group,
 		})
+		// Ignore ResourceInUseExceptions in case the NodeGroup is already being
+		// deleted.
+		var resourceInUseExceptionErr *types.ResourceInUseException
+		if err != nil && !errors.As(err, &resourceInUseExceptionErr) {
+			return err
+		}
+	}
+
+	// Wait for all NodeGroups to be deleted, otherwise the cluster cannot be
+	// deleted.
+	for _, group := range nodeGroups {
+		nodegroupDeletedWaiter := eks.NewNodegroupDeletedWaiter(client)
+		log.Info().
+			Str("ClusterName", *cluster.Name).
+			Str("NodegroupName", group).
+			Msg("NodegroupDeletedWaiter.Wait")
+		err := nodegroupDeletedWaiter.Wait(ctx, &eks.DescribeNodegroupInput{
+			ClusterName:   cluster.Name,
+			NodegroupName: &group,
+		}, nodegroupDeletedWaiterMaxDuration)
+		log.Err(err).
+			Msg("NodegroupDeletedWaiter.Wait")
 		if err != nil {
 			return err
 		}
 	}
-	return err
+	return nil
 }
 
 func listClusterNodeGroups(ctx context.Context, client *eks.Client, cluster *types.Cluster) ([]string, error) {
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/opensearch"
+	"github.com/aws/aws-sdk-go-v2/service/opensearch/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// openSearchDescribeDomainsMaxDomains is the maximum number of domains
+// accepted by a single DescribeDomains call.
+const openSearchDescribeDomainsMaxDomains = 5
+
+// deleteOpenSearchDomains deletes domains and waits for them to be deleted.
+func deleteOpenSearchDomains(ctx context.Context, client *opensearch.Client, domains []types.DomainStatus) error {
+	var errs error
+	deletingDomainNames := newStringSet()
+	for _, domain := range domains {
+		if domain.DomainName == nil {
+			continue
+		}
+		if aws.ToBool(domain.Deleted) {
+			deletingDomainNames[*domain.DomainName] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteDomain(ctx, &opensearch.DeleteDomainInput{
+			DomainName: domain.DomainName,
+		})
+		log.Err(err).
+			Str("DomainName", *domain.DomainName).
+			Msg("DeleteDomain")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingDomainNames[*domain.DomainName] = struct{}{}
+		}
+	}
+	if len(deletingDomainNames) == 0 {
+		return errs
+	}
+
+	// Wait for the domains to be deleted. Deleted domains are no longer
+	// listed.
+	var remaining []string
+	err := poll(ctx, openSearchDomainPollInterval, openSearchDomainDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		domainNames, err := listOpenSearchDomainNames(ctx, client)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, domainName := range domainNames {
+			if deletingDomainNames.contains(domainName) {
+				remaining = append(remaining, domainName)
+			}
+		}
+		log.Info().
+			Strs("DomainNames", remaining).
+			Msg("ListDomainNames")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for OpenSearch domains to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+// deleteOpenSearchDomainsInVpc deletes the OpenSearch domains in the VPC vpcId
+// and waits for them to be deleted.
+func deleteOpenSearchDomainsInVpc(ctx context.Context, client *opensearch.Client, vpcId string) error {
+	domains, err := listOpenSearchDomains(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listOpenSearchDomains")
+		return err
+	}
+	log.Info().
+		Strs("domainNames", openSearchDomainNames(domains)).
+		Msg("listOpenSearchDomains")
+	if len(domains) == 0 {
+		return nil
+	}
+
+	err = deleteOpenSearchDomains(ctx, client, domains)
+	log.Err(err).
+		Strs("domainNames", openSearchDomainNames(domains)).
+		Msg("deleteOpenSearchDomains")
+	return err
+}
+
+func listOpenSearchDomainNames(ctx context.Context, client *opensearch.Client) ([]string, error) {
+	output, err := client.ListDomainNames(ctx, &opensearch.ListDomainNamesInput{})
+	if err != nil {
+		return nil, err
+	}
+	domainNames := make([]string, 0, len(output.DomainNames))
+	for _, domainInfo := range output.DomainNames {
+		if domainInfo.DomainName != nil {
+			domainNames = append(domainNames, *domainInfo.DomainName)
+		}
+	}
+	return domainNames, nil
+}
+
+// listOpenSearchDomains returns the OpenSearch domains in the VPC vpcId.
+func listOpenSearchDomains(ctx context.Context, client *opensearch.Client, vpcId string) ([]types.DomainStatus, error) {
+	domainNames, err := listOpenSearchDomainNames(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	var domains []types.DomainStatus
+	for start := 0; start < len(domainNames); start += openSearchDescribeDomainsMaxDomains {
+		end := start + openSearchDescribeDomainsMaxDomains
+		if end > len(domainNames) {
+			end = len(domainNames)
+		}
+		output, err := client.DescribeDomains(ctx, &opensearch.DescribeDomainsInput{
+			DomainNames: domainNames[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range output.DomainStatusList {
+			if domain.VPCOptions != nil && aws.ToString(domain.VPCOptions.VPCId) == vpcId {
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains, nil
+}
+
+func openSearchDomainNames(domains []types.DomainStatus) []string {
+	domainNames := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if domain.DomainName != nil {
+			domainNames = append(domainNames, *domain.DomainName)
+		}
+	}
+	return domainNames
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errPollTimeout is returned by poll when its condition is not met in time.
+var errPollTimeout = errors.New("timed out")
+
+// poll calls condition immediately and then every interval until it returns
+// true or an error. It is used to wait for resources for which the AWS SDK
+// does not provide a waiter. If condition does not return true within timeout
+// then poll returns errPollTimeout.
+func poll(ctx context.Context, interval, timeout time.Duration, condition func(context.Context) (bool, error)) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		done, err := condition(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			return errPollTimeout
+		case <-ticker.C:
+		}
+	}
+}
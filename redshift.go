@@ -0,0 +1,409 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/redshift/types"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
+	redshiftserverlesstypes "github.com/aws/aws-sdk-go-v2/service/redshiftserverless/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteRedshiftClusters deletes clusters and waits for them to be deleted. If
+// finalSnapshotPrefix is set then a final snapshot of each cluster is taken,
+// named with the prefix followed by its identifier.
+func deleteRedshiftClusters(ctx context.Context, client *redshift.Client, clusters []types.Cluster, finalSnapshotPrefix string) (errs error) {
+	var deletingClusterIdentifiers []string
+	for _, cluster := range clusters {
+		if cluster.ClusterIdentifier == nil {
+			continue
+		}
+		if aws.ToString(cluster.ClusterStatus) == "deleting" {
+			deletingClusterIdentifiers = append(deletingClusterIdentifiers, *cluster.ClusterIdentifier)
+			continue
+		}
+		input := redshift.DeleteClusterInput{
+			ClusterIdentifier:        cluster.ClusterIdentifier,
+			SkipFinalClusterSnapshot: finalSnapshotPrefix == "",
+		}
+		if finalSnapshotPrefix != "" {
+			input.FinalClusterSnapshotIdentifier = aws.String(finalSnapshotPrefix + *cluster.ClusterIdentifier)
+		}
+		_, err := client.DeleteCluster(ctx, &input)
+		log.Err(err).
+			Str("ClusterIdentifier", *cluster.ClusterIdentifier).
+			Str("FinalClusterSnapshotIdentifier", aws.ToString(input.FinalClusterSnapshotIdentifier)).
+			Msg("DeleteCluster")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingClusterIdentifiers = append(deletingClusterIdentifiers, *cluster.ClusterIdentifier)
+		}
+	}
+
+	// Wait for the clusters to be deleted.
+	clusterDeletedWaiter := redshift.NewClusterDeletedWaiter(client)
+	for _, clusterIdentifier := range deletingClusterIdentifiers {
+		log.Info().
+			Str("ClusterIdentifier", clusterIdentifier).
+			Msg("ClusterDeletedWaiter.Wait")
+		err := clusterDeletedWaiter.Wait(ctx, &redshift.DescribeClustersInput{
+			ClusterIdentifier: aws.String(clusterIdentifier),
+		}, redshiftDeletedWaiterMaxDuration)
+		log.Err(err).
+			Str("ClusterIdentifier", clusterIdentifier).
+			Msg("ClusterDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// deleteRedshiftEndpointAccesses deletes the Redshift-managed VPC endpoints of
+// provisioned clusters and serverless workgroups in the VPC vpcId, and waits
+// for them to be deleted.
+func deleteRedshiftEndpointAccesses(ctx context.Context, client *redshift.Client, serverlessClient *redshiftserverless.Client, vpcId string) error {
+	var errs error
+	endpointNames, serverlessEndpointNames, err := listRedshiftEndpointAccessNames(ctx, client, serverlessClient, vpcId)
+	if err != nil {
+		return err
+	}
+	if len(endpointNames) == 0 && len(serverlessEndpointNames) == 0 {
+		return nil
+	}
+	for _, endpointName := range endpointNames {
+		_, err := client.DeleteEndpointAccess(ctx, &redshift.DeleteEndpointAccessInput{
+			EndpointName: aws.String(endpointName),
+		})
+		log.Err(err).
+			Str("EndpointName", endpointName).
+			Msg("DeleteEndpointAccess")
+		errs = multierr.Append(errs, err)
+	}
+	for _, endpointName := range serverlessEndpointNames {
+		_, err := serverlessClient.DeleteEndpointAccess(ctx, &redshiftserverless.DeleteEndpointAccessInput{
+			EndpointName: aws.String(endpointName),
+		})
+		log.Err(err).
+			Str("EndpointName", endpointName).
+			Msg("DeleteEndpointAccess")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+
+	// Wait for the endpoints to be deleted.
+	var remaining []string
+	err = poll(ctx, redshiftPollInterval, redshiftDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		endpointNames, serverlessEndpointNames, err := listRedshiftEndpointAccessNames(ctx, client, serverlessClient, vpcId)
+		if err != nil {
+			return false, err
+		}
+		remaining = append(endpointNames, serverlessEndpointNames...)
+		log.Info().
+			Strs("EndpointNames", remaining).
+			Msg("DescribeEndpointAccess")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for Redshift endpoints to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return err
+}
+
+// deleteRedshiftInVpc deletes the Redshift-managed VPC endpoints, provisioned
+// Redshift clusters, and Redshift Serverless workgroups in the VPC vpcId,
+// waits for them to be deleted, and then deletes the VPC's cluster subnet
+// groups. If opts.finalSnapshotPrefix is set then a final snapshot of each
+// provisioned cluster is taken. Serverless workgroups do not store data, which
+// is kept by their namespaces, so the namespaces are not deleted. It
+// accumulates errors.
+func deleteRedshiftInVpc(ctx context.Context, client *redshift.Client, serverlessClient *redshiftserverless.Client, ec2Client *ec2.Client, vpcId string, opts *options) (errs error) {
+	err := deleteRedshiftEndpointAccesses(ctx, client, serverlessClient, vpcId)
+	log.Err(err).
+		Str("vpcId", vpcId).
+		Msg("deleteRedshiftEndpointAccesses")
+	if err != nil {
+		return err
+	}
+
+	if clusters, err := listRedshiftClusters(ctx, client, vpcId); err != nil {
+		log.Err(err).
+			Msg("listRedshiftClusters")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("clusterIdentifiers", redshiftClusterIdentifiers(clusters)).
+			Msg("listRedshiftClusters")
+		if len(clusters) > 0 {
+			err := deleteRedshiftClusters(ctx, client, clusters, opts.finalSnapshotPrefix)
+			log.Err(err).
+				Strs("clusterIdentifiers", redshiftClusterIdentifiers(clusters)).
+				Msg("deleteRedshiftClusters")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if subnets, err := listSubnets(ctx, ec2Client, vpcId); err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		errs = multierr.Append(errs, err)
+	} else if workgroups, err := listRedshiftServerlessWorkgroups(ctx, serverlessClient, newStringSet(subnetIds(subnets)...)); err != nil {
+		log.Err(err).
+			Msg("listRedshiftServerlessWorkgroups")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("workgroupNames", redshiftServerlessWorkgroupNames(workgroups)).
+			Msg("listRedshiftServerlessWorkgroups")
+		if len(workgroups) > 0 {
+			err := deleteRedshiftServerlessWorkgroups(ctx, serverlessClient, workgroups)
+			log.Err(err).
+				Strs("workgroupNames", redshiftServerlessWorkgroupNames(workgroups)).
+				Msg("deleteRedshiftServerlessWorkgroups")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return
+	}
+
+	clusterSubnetGroups, err := listRedshiftClusterSubnetGroups(ctx, client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listRedshiftClusterSubnetGroups")
+		return err
+	}
+	log.Info().
+		Strs("clusterSubnetGroupNames", redshiftClusterSubnetGroupNames(clusterSubnetGroups)).
+		Msg("listRedshiftClusterSubnetGroups")
+	if len(clusterSubnetGroups) > 0 {
+		err := deleteRedshiftClusterSubnetGroups(ctx, client, clusterSubnetGroups)
+		log.Err(err).
+			Strs("clusterSubnetGroupNames", redshiftClusterSubnetGroupNames(clusterSubnetGroups)).
+			Msg("deleteRedshiftClusterSubnetGroups")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+func deleteRedshiftClusterSubnetGroups(ctx context.Context, client *redshift.Client, clusterSubnetGroups []types.ClusterSubnetGroup) (errs error) {
+	for _, clusterSubnetGroup := range clusterSubnetGroups {
+		if clusterSubnetGroup.ClusterSubnetGroupName == nil {
+			continue
+		}
+		_, err := client.DeleteClusterSubnetGroup(ctx, &redshift.DeleteClusterSubnetGroupInput{
+			ClusterSubnetGroupName: clusterSubnetGroup.ClusterSubnetGroupName,
+		})
+		log.Err(err).
+			Str("ClusterSubnetGroupName", *clusterSubnetGroup.ClusterSubnetGroupName).
+			Msg("DeleteClusterSubnetGroup")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteRedshiftServerlessWorkgroups deletes workgroups and waits for them to
+// be deleted.
+func deleteRedshiftServerlessWorkgroups(ctx context.Context, client *redshiftserverless.Client, workgroups []redshiftserverlesstypes.Workgroup) error {
+	var errs error
+	deletingWorkgroupNames := newStringSet()
+	for _, workgroup := range workgroups {
+		if workgroup.WorkgroupName == nil {
+			continue
+		}
+		if workgroup.Status == redshiftserverlesstypes.WorkgroupStatusDeleting {
+			deletingWorkgroupNames[*workgroup.WorkgroupName] = struct{}{}
+			continue
+		}
+		_, err := client.DeleteWorkgroup(ctx, &redshiftserverless.DeleteWorkgroupInput{
+			WorkgroupName: workgroup.WorkgroupName,
+		})
+		log.Err(err).
+			Str("WorkgroupName", *workgroup.WorkgroupName).
+			Msg("DeleteWorkgroup")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingWorkgroupNames[*workgroup.WorkgroupName] = struct{}{}
+		}
+	}
+	if len(deletingWorkgroupNames) == 0 {
+		return errs
+	}
+
+	// Wait for the workgroups to be deleted.
+	var remaining []string
+	err := poll(ctx, redshiftPollInterval, redshiftDeletedWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		workgroups, err := listRedshiftServerlessWorkgroups(ctx, client, nil)
+		if err != nil {
+			return false, err
+		}
+		remaining = nil
+		for _, workgroupName := range redshiftServerlessWorkgroupNames(workgroups) {
+			if deletingWorkgroupNames.contains(workgroupName) {
+				remaining = append(remaining, workgroupName)
+			}
+		}
+		log.Info().
+			Strs("WorkgroupNames", remaining).
+			Msg("ListWorkgroups")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for Redshift Serverless workgroups to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return multierr.Append(errs, err)
+}
+
+func listRedshiftClusters(ctx context.Context, client *redshift.Client, vpcId string) ([]types.Cluster, error) {
+	input := redshift.DescribeClustersInput{}
+	var clusters []types.Cluster
+	for {
+		output, err := client.DescribeClusters(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, cluster := range output.Clusters {
+			if aws.ToString(cluster.VpcId) == vpcId {
+				clusters = append(clusters, cluster)
+			}
+		}
+		if output.Marker == nil {
+			return clusters, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+func listRedshiftClusterSubnetGroups(ctx context.Context, client *redshift.Client, vpcId string) ([]types.ClusterSubnetGroup, error) {
+	input := redshift.DescribeClusterSubnetGroupsInput{}
+	var clusterSubnetGroups []types.ClusterSubnetGroup
+	for {
+		output, err := client.DescribeClusterSubnetGroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, clusterSubnetGroup := range output.ClusterSubnetGroups {
+			if aws.ToString(clusterSubnetGroup.VpcId) == vpcId {
+				clusterSubnetGroups = append(clusterSubnetGroups, clusterSubnetGroup)
+			}
+		}
+		if output.Marker == nil {
+			return clusterSubnetGroups, nil
+		}
+		input.Marker = output.Marker
+	}
+}
+
+// listRedshiftEndpointAccessNames returns the names of the Redshift-managed
+// VPC endpoints of provisioned clusters and of serverless workgroups in the
+// VPC vpcId.
+func listRedshiftEndpointAccessNames(ctx context.Context, client *redshift.Client, serverlessClient *redshiftserverless.Client, vpcId string) (endpointNames, serverlessEndpointNames []string, err error) {
+	input := redshift.DescribeEndpointAccessInput{
+		VpcId: aws.String(vpcId),
+	}
+	for {
+		output, err := client.DescribeEndpointAccess(ctx, &input)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, endpointAccess := range output.EndpointAccessList {
+			if endpointAccess.EndpointName != nil {
+				endpointNames = append(endpointNames, *endpointAccess.EndpointName)
+			}
+		}
+		if output.Marker == nil {
+			break
+		}
+		input.Marker = output.Marker
+	}
+
+	serverlessInput := redshiftserverless.ListEndpointAccessInput{
+		VpcId: aws.String(vpcId),
+	}
+	for {
+		output, err := serverlessClient.ListEndpointAccess(ctx, &serverlessInput)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, endpointAccess := range output.Endpoints {
+			if endpointAccess.EndpointName != nil {
+				serverlessEndpointNames = append(serverlessEndpointNames, *endpointAccess.EndpointName)
+			}
+		}
+		if output.NextToken == nil {
+			break
+		}
+		serverlessInput.NextToken = output.NextToken
+	}
+
+	return endpointNames, serverlessEndpointNames, nil
+}
+
+// listRedshiftServerlessWorkgroups returns the Redshift Serverless workgroups
+// with a subnet in subnetIds, or all workgroups if subnetIds is nil.
+func listRedshiftServerlessWorkgroups(ctx context.Context, client *redshiftserverless.Client, subnetIds stringSet) ([]redshiftserverlesstypes.Workgroup, error) {
+	input := redshiftserverless.ListWorkgroupsInput{}
+	var workgroups []redshiftserverlesstypes.Workgroup
+	for {
+		output, err := client.ListWorkgroups(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	WORKGROUP:
+		for _, workgroup := range output.Workgroups {
+			if subnetIds == nil {
+				workgroups = append(workgroups, workgroup)
+				continue
+			}
+			for _, subnetId := range workgroup.SubnetIds {
+				if subnetIds.contains(subnetId) {
+					workgroups = append(workgroups, workgroup)
+					continue WORKGROUP
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return workgroups, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func redshiftClusterIdentifiers(clusters []types.Cluster) []string {
+	clusterIdentifiers := make([]string, 0, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.ClusterIdentifier != nil {
+			clusterIdentifiers = append(clusterIdentifiers, *cluster.ClusterIdentifier)
+		}
+	}
+	return clusterIdentifiers
+}
+
+func redshiftClusterSubnetGroupNames(clusterSubnetGroups []types.ClusterSubnetGroup) []string {
+	clusterSubnetGroupNames := make([]string, 0, len(clusterSubnetGroups))
+	for _, clusterSubnetGroup := range clusterSubnetGroups {
+		if clusterSubnetGroup.ClusterSubnetGroupName != nil {
+			clusterSubnetGroupNames = append(clusterSubnetGroupNames, *clusterSubnetGroup.ClusterSubnetGroupName)
+		}
+	}
+	return clusterSubnetGroupNames
+}
+
+func redshiftServerlessWorkgroupNames(workgroups []redshiftserverlesstypes.Workgroup) []string {
+	workgroupNames := make([]string, 0, len(workgroups))
+	for _, workgroup := range workgroups {
+		if workgroup.WorkgroupName != nil {
+			workgroupNames = append(workgroupNames, *workgroup.WorkgroupName)
+		}
+	}
+	return workgroupNames
+}
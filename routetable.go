@@ -30,6 +30,19 @@ func deleteRouteTables(ctx context.Context, client *ec2.Client, vpcId string, ro
 	return
 }
 
+// excludeRouteTables splits routeTables into those whose IDs are not in
+// excludedRouteTableIds and those whose IDs are.
+func excludeRouteTables(routeTables []types.RouteTable, excludedRouteTableIds stringSet) (includedRouteTables, excludedRouteTables []types.RouteTable) {
+	for _, routeTable := range routeTables {
+		if routeTable.RouteTableId != nil && excludedRouteTableIds.contains(*routeTable.RouteTableId) {
+			excludedRouteTables = append(excludedRouteTables, routeTable)
+		} else {
+			includedRouteTables = append(includedRouteTables, routeTable)
+		}
+	}
+	return
+}
+
 func listRouteTables(ctx context.Context, client *ec2.Client, vpcId string) ([]types.RouteTable, error) {
 	input := ec2.DescribeRouteTablesInput{
 		Filters: []types.Filter{
@@ -53,6 +66,19 @@ func listRouteTables(ctx context.Context, client *ec2.Client, vpcId string) ([]t
 	}
 }
 
+// partitionSharedRouteTables splits routeTables into those that may be deleted and
+// those that are shared with the cluster clusterName and must be kept.
+func partitionSharedRouteTables(routeTables []types.RouteTable, clusterName string) (ownedRouteTables, sharedRouteTables []types.RouteTable) {
+	for _, routeTable := range routeTables {
+		if isSharedWithCluster(routeTable.Tags, clusterName) {
+			sharedRouteTables = append(sharedRouteTables, routeTable)
+		} else {
+			ownedRouteTables = append(ownedRouteTables, routeTable)
+		}
+	}
+	return
+}
+
 func routeTableIds(routeTables []types.RouteTable) []string {
 	routeTableIds := make([]string, 0, len(routeTables))
 	for _, routeTable := range routeTables {
@@ -0,0 +1,645 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker/types"
+	"github.com/aws/smithy-go"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteSageMakerDomain deletes the apps, spaces, and user profiles of the
+// domain domainId, waiting for each to be deleted, and then deletes the domain
+// and waits for it to be deleted. If deleteHomeEfsFileSystem is true then the
+// domain's home EFS file system is deleted too.
+func deleteSageMakerDomain(ctx context.Context, client *sagemaker.Client, domainId string, deleteHomeEfsFileSystem bool) error {
+	// Delete the apps.
+	apps, err := listSageMakerApps(ctx, client, domainId)
+	if err != nil {
+		return err
+	}
+	var errs error
+	for _, app := range apps {
+		if app.Status == types.AppStatusDeleting {
+			continue
+		}
+		_, err := client.DeleteApp(ctx, &sagemaker.DeleteAppInput{
+			AppName:         app.AppName,
+			AppType:         app.AppType,
+			DomainId:        app.DomainId,
+			SpaceName:       app.SpaceName,
+			UserProfileName: app.UserProfileName,
+		})
+		log.Err(err).
+			Str("DomainId", domainId).
+			Str("AppName", aws.ToString(app.AppName)).
+			Msg("DeleteApp")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+	if len(apps) > 0 {
+		err := waitForSageMakerResourcesDeleted(ctx, "apps", "ListApps", func(ctx context.Context) ([]string, error) {
+			apps, err := listSageMakerApps(ctx, client, domainId)
+			if err != nil {
+				return nil, err
+			}
+			appNames := make([]string, 0, len(apps))
+			for _, app := range apps {
+				appNames = append(appNames, aws.ToString(app.AppName))
+			}
+			return appNames, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the spaces.
+	spaces, err := listSageMakerSpaces(ctx, client, domainId)
+	if err != nil {
+		return err
+	}
+	for _, space := range spaces {
+		if space.Status == types.SpaceStatusDeleting {
+			continue
+		}
+		_, err := client.DeleteSpace(ctx, &sagemaker.DeleteSpaceInput{
+			DomainId:  aws.String(domainId),
+			SpaceName: space.SpaceName,
+		})
+		log.Err(err).
+			Str("DomainId", domainId).
+			Str("SpaceName", aws.ToString(space.SpaceName)).
+			Msg("DeleteSpace")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+	if len(spaces) > 0 {
+		err := waitForSageMakerResourcesDeleted(ctx, "spaces", "ListSpaces", func(ctx context.Context) ([]string, error) {
+			spaces, err := listSageMakerSpaces(ctx, client, domainId)
+			if err != nil {
+				return nil, err
+			}
+			spaceNames := make([]string, 0, len(spaces))
+			for _, space := range spaces {
+				spaceNames = append(spaceNames, aws.ToString(space.SpaceName))
+			}
+			return spaceNames, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the user profiles.
+	userProfiles, err := listSageMakerUserProfiles(ctx, client, domainId)
+	if err != nil {
+		return err
+	}
+	for _, userProfile := range userProfiles {
+		if userProfile.Status == types.UserProfileStatusDeleting {
+			continue
+		}
+		_, err := client.DeleteUserProfile(ctx, &sagemaker.DeleteUserProfileInput{
+			DomainId:        aws.String(domainId),
+			UserProfileName: userProfile.UserProfileName,
+		})
+		log.Err(err).
+			Str("DomainId", domainId).
+			Str("UserProfileName", aws.ToString(userProfile.UserProfileName)).
+			Msg("DeleteUserProfile")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return errs
+	}
+	if len(userProfiles) > 0 {
+		err := waitForSageMakerResourcesDeleted(ctx, "user profiles", "ListUserProfiles", func(ctx context.Context) ([]string, error) {
+			userProfiles, err := listSageMakerUserProfiles(ctx, client, domainId)
+			if err != nil {
+				return nil, err
+			}
+			userProfileNames := make([]string, 0, len(userProfiles))
+			for _, userProfile := range userProfiles {
+				userProfileNames = append(userProfileNames, aws.ToString(userProfile.UserProfileName))
+			}
+			return userProfileNames, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Delete the domain.
+	retentionPolicy := types.RetentionPolicy{
+		HomeEfsFileSystem: types.RetentionTypeRetain,
+	}
+	if deleteHomeEfsFileSystem {
+		retentionPolicy.HomeEfsFileSystem = types.RetentionTypeDelete
+	}
+	_, err = client.DeleteDomain(ctx, &sagemaker.DeleteDomainInput{
+		DomainId:        aws.String(domainId),
+		RetentionPolicy: &retentionPolicy,
+	})
+	log.Err(err).
+		Str("DomainId", domainId).
+		Str("HomeEfsFileSystem", string(retentionPolicy.HomeEfsFileSystem)).
+		Msg("DeleteDomain")
+	if err != nil {
+		return err
+	}
+	return waitForSageMakerResourcesDeleted(ctx, "domains", "DescribeDomain", func(ctx context.Context) ([]string, error) {
+		output, err := client.DescribeDomain(ctx, &sagemaker.DescribeDomainInput{
+			DomainId: aws.String(domainId),
+		})
+		if err != nil {
+			var resourceNotFound *types.ResourceNotFound
+			if errors.As(err, &resourceNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if output.Status == types.DomainStatusDeleteFailed {
+			return nil, fmt.Errorf("failed to delete SageMaker domain %s: %s", domainId, aws.ToString(output.FailureReason))
+		}
+		return []string{domainId}, nil
+	})
+}
+
+// deleteSageMakerEndpoints deletes endpointNames and waits for them to be
+// deleted.
+func deleteSageMakerEndpoints(ctx context.Context, client *sagemaker.Client, endpointNames []string) (errs error) {
+	var deletingEndpointNames []string
+	for _, endpointName := range endpointNames {
+		_, err := client.DeleteEndpoint(ctx, &sagemaker.DeleteEndpointInput{
+			EndpointName: aws.String(endpointName),
+		})
+		log.Err(err).
+			Str("EndpointName", endpointName).
+			Msg("DeleteEndpoint")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingEndpointNames = append(deletingEndpointNames, endpointName)
+		}
+	}
+
+	// Wait for the endpoints to be deleted.
+	endpointDeletedWaiter := sagemaker.NewEndpointDeletedWaiter(client)
+	for _, endpointName := range deletingEndpointNames {
+		log.Info().
+			Str("EndpointName", endpointName).
+			Msg("EndpointDeletedWaiter.Wait")
+		err := endpointDeletedWaiter.Wait(ctx, &sagemaker.DescribeEndpointInput{
+			EndpointName: aws.String(endpointName),
+		}, sageMakerWaiterMaxDuration)
+		log.Err(err).
+			Str("EndpointName", endpointName).
+			Msg("EndpointDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+func deleteSageMakerModels(ctx context.Context, client *sagemaker.Client, modelNames []string) (errs error) {
+	for _, modelName := range modelNames {
+		_, err := client.DeleteModel(ctx, &sagemaker.DeleteModelInput{
+			ModelName: aws.String(modelName),
+		})
+		log.Err(err).
+			Str("ModelName", modelName).
+			Msg("DeleteModel")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// deleteSageMakerNotebookInstances stops notebookInstances, waits for them to
+// stop, and then deletes them and waits for them to be deleted.
+func deleteSageMakerNotebookInstances(ctx context.Context, client *sagemaker.Client, notebookInstances []*sagemaker.DescribeNotebookInstanceOutput) (errs error) {
+	// Stop the notebook instances.
+	var stoppingNotebookInstanceNames []string
+	for _, notebookInstance := range notebookInstances {
+		if notebookInstance.NotebookInstanceName == nil {
+			continue
+		}
+		switch notebookInstance.NotebookInstanceStatus {
+		case types.NotebookInstanceStatusInService:
+			_, err := client.StopNotebookInstance(ctx, &sagemaker.StopNotebookInstanceInput{
+				NotebookInstanceName: notebookInstance.NotebookInstanceName,
+			})
+			log.Err(err).
+				Str("NotebookInstanceName", *notebookInstance.NotebookInstanceName).
+				Msg("StopNotebookInstance")
+			errs = multierr.Append(errs, err)
+			if err == nil {
+				stoppingNotebookInstanceNames = append(stoppingNotebookInstanceNames, *notebookInstance.NotebookInstanceName)
+			}
+		case types.NotebookInstanceStatusStopping:
+			stoppingNotebookInstanceNames = append(stoppingNotebookInstanceNames, *notebookInstance.NotebookInstanceName)
+		}
+	}
+
+	// Wait for the notebook instances to stop.
+	notebookInstanceStoppedWaiter := sagemaker.NewNotebookInstanceStoppedWaiter(client)
+	for _, notebookInstanceName := range stoppingNotebookInstanceNames {
+		log.Info().
+			Str("NotebookInstanceName", notebookInstanceName).
+			Msg("NotebookInstanceStoppedWaiter.Wait")
+		err := notebookInstanceStoppedWaiter.Wait(ctx, &sagemaker.DescribeNotebookInstanceInput{
+			NotebookInstanceName: aws.String(notebookInstanceName),
+		}, sageMakerWaiterMaxDuration)
+		log.Err(err).
+			Str("NotebookInstanceName", notebookInstanceName).
+			Msg("NotebookInstanceStoppedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+	if errs != nil {
+		return
+	}
+
+	// Delete the notebook instances.
+	var deletingNotebookInstanceNames []string
+	for _, notebookInstance := range notebookInstances {
+		if notebookInstance.NotebookInstanceName == nil {
+			continue
+		}
+		if notebookInstance.NotebookInstanceStatus == types.NotebookInstanceStatusDeleting {
+			deletingNotebookInstanceNames = append(deletingNotebookInstanceNames, *notebookInstance.NotebookInstanceName)
+			continue
+		}
+		_, err := client.DeleteNotebookInstance(ctx, &sagemaker.DeleteNotebookInstanceInput{
+			NotebookInstanceName: notebookInstance.NotebookInstanceName,
+		})
+		log.Err(err).
+			Str("NotebookInstanceName", *notebookInstance.NotebookInstanceName).
+			Msg("DeleteNotebookInstance")
+		errs = multierr.Append(errs, err)
+		if err == nil {
+			deletingNotebookInstanceNames = append(deletingNotebookInstanceNames, *notebookInstance.NotebookInstanceName)
+		}
+	}
+
+	// Wait for the notebook instances to be deleted.
+	notebookInstanceDeletedWaiter := sagemaker.NewNotebookInstanceDeletedWaiter(client)
+	for _, notebookInstanceName := range deletingNotebookInstanceNames {
+		log.Info().
+			Str("NotebookInstanceName", notebookInstanceName).
+			Msg("NotebookInstanceDeletedWaiter.Wait")
+		err := notebookInstanceDeletedWaiter.Wait(ctx, &sagemaker.DescribeNotebookInstanceInput{
+			NotebookInstanceName: aws.String(notebookInstanceName),
+		}, sageMakerWaiterMaxDuration)
+		log.Err(err).
+			Str("NotebookInstanceName", notebookInstanceName).
+			Msg("NotebookInstanceDeletedWaiter.Wait")
+		errs = multierr.Append(errs, err)
+	}
+
+	return
+}
+
+// deleteSageMakerInVpc deletes the SageMaker domains, notebook instances, and
+// endpoints and models that are attached to the VPC vpcId, and waits for them
+// to be deleted. The home EFS file systems of the domains are only deleted if
+// opts.deleteSageMakerHomeEfsFileSystems is true. It accumulates errors.
+func deleteSageMakerInVpc(ctx context.Context, client *sagemaker.Client, ec2Client *ec2.Client, vpcId string, opts *options) (errs error) {
+	subnets, err := listSubnets(ctx, ec2Client, vpcId)
+	if err != nil {
+		log.Err(err).
+			Msg("listSubnets")
+		return err
+	}
+	vpcSubnetIds := newStringSet(subnetIds(subnets)...)
+
+	if domainIds, err := listSageMakerDomainIds(ctx, client, vpcId); err != nil {
+		log.Err(err).
+			Msg("listSageMakerDomainIds")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("domainIds", domainIds).
+			Msg("listSageMakerDomainIds")
+		for _, domainId := range domainIds {
+			err := deleteSageMakerDomain(ctx, client, domainId, opts.deleteSageMakerHomeEfsFileSystems)
+			log.Err(err).
+				Str("domainId", domainId).
+				Msg("deleteSageMakerDomain")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if notebookInstances, err := listSageMakerNotebookInstances(ctx, client, vpcSubnetIds); err != nil {
+		log.Err(err).
+			Msg("listSageMakerNotebookInstances")
+		errs = multierr.Append(errs, err)
+	} else {
+		log.Info().
+			Strs("notebookInstanceNames", sageMakerNotebookInstanceNames(notebookInstances)).
+			Msg("listSageMakerNotebookInstances")
+		if len(notebookInstances) > 0 {
+			err := deleteSageMakerNotebookInstances(ctx, client, notebookInstances)
+			log.Err(err).
+				Strs("notebookInstanceNames", sageMakerNotebookInstanceNames(notebookInstances)).
+				Msg("deleteSageMakerNotebookInstances")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	modelNames, err := listSageMakerModelNames(ctx, client, vpcSubnetIds)
+	if err != nil {
+		log.Err(err).
+			Msg("listSageMakerModelNames")
+		return multierr.Append(errs, err)
+	}
+	log.Info().
+		Strs("modelNames", modelNames).
+		Msg("listSageMakerModelNames")
+	if len(modelNames) == 0 {
+		return
+	}
+
+	endpointNames, err := listSageMakerEndpointNames(ctx, client, newStringSet(modelNames...))
+	if err != nil {
+		log.Err(err).
+			Msg("listSageMakerEndpointNames")
+		return multierr.Append(errs, err)
+	}
+	log.Info().
+		Strs("endpointNames", endpointNames).
+		Msg("listSageMakerEndpointNames")
+	if len(endpointNames) > 0 {
+		err := deleteSageMakerEndpoints(ctx, client, endpointNames)
+		log.Err(err).
+			Strs("endpointNames", endpointNames).
+			Msg("deleteSageMakerEndpoints")
+		if err != nil {
+			return multierr.Append(errs, err)
+		}
+	}
+
+	err = deleteSageMakerModels(ctx, client, modelNames)
+	log.Err(err).
+		Strs("modelNames", modelNames).
+		Msg("deleteSageMakerModels")
+	return multierr.Append(errs, err)
+}
+
+// listSageMakerApps returns the apps of the domain domainId that have not
+// been deleted.
+func listSageMakerApps(ctx context.Context, client *sagemaker.Client, domainId string) ([]types.AppDetails, error) {
+	input := sagemaker.ListAppsInput{
+		DomainIdEquals: aws.String(domainId),
+	}
+	var apps []types.AppDetails
+	for {
+		output, err := client.ListApps(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, app := range output.Apps {
+			if app.Status != types.AppStatusDeleted {
+				apps = append(apps, app)
+			}
+		}
+		if output.NextToken == nil {
+			return apps, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSageMakerDomainIds returns the IDs of the SageMaker domains in the VPC
+// vpcId.
+func listSageMakerDomainIds(ctx context.Context, client *sagemaker.Client, vpcId string) ([]string, error) {
+	domains, err := listSageMakerDomains(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	var domainIds []string
+	for _, domain := range domains {
+		output, err := client.DescribeDomain(ctx, &sagemaker.DescribeDomainInput{
+			DomainId: domain.DomainId,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if aws.ToString(output.VpcId) == vpcId {
+			domainIds = append(domainIds, aws.ToString(domain.DomainId))
+		}
+	}
+	return domainIds, nil
+}
+
+// listSageMakerDomains returns the SageMaker domains that are not being, or
+// have not failed to be, deleted.
+func listSageMakerDomains(ctx context.Context, client *sagemaker.Client) ([]types.DomainDetails, error) {
+	input := sagemaker.ListDomainsInput{}
+	var domains []types.DomainDetails
+	for {
+		output, err := client.ListDomains(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, domain := range output.Domains {
+			if domain.Status == types.DomainStatusDeleting || domain.Status == types.DomainStatusDeleteFailed {
+				continue
+			}
+			domains = append(domains, domain)
+		}
+		if output.NextToken == nil {
+			return domains, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSageMakerEndpointNames returns the names of the SageMaker endpoints that
+// serve one of modelNames.
+func listSageMakerEndpointNames(ctx context.Context, client *sagemaker.Client, modelNames stringSet) ([]string, error) {
+	input := sagemaker.ListEndpointsInput{}
+	var endpointNames []string
+	for {
+		output, err := client.ListEndpoints(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	ENDPOINT:
+		for _, endpoint := range output.Endpoints {
+			describeEndpointOutput, err := client.DescribeEndpoint(ctx, &sagemaker.DescribeEndpointInput{
+				EndpointName: endpoint.EndpointName,
+			})
+			if err != nil {
+				return nil, err
+			}
+			describeEndpointConfigOutput, err := client.DescribeEndpointConfig(ctx, &sagemaker.DescribeEndpointConfigInput{
+				EndpointConfigName: describeEndpointOutput.EndpointConfigName,
+			})
+			if err != nil {
+				// The endpoint configuration may have been deleted while
+				// the endpoint still exists.
+				if apiError := smithy.APIError(nil); errors.As(err, &apiError) && apiError.ErrorCode() == "ValidationException" {
+					log.Err(err).
+						Str("EndpointName", aws.ToString(endpoint.EndpointName)).
+						Msg("DescribeEndpointConfig")
+					continue
+				}
+				return nil, err
+			}
+			var productionVariants []types.ProductionVariant
+			productionVariants = append(productionVariants, describeEndpointConfigOutput.ProductionVariants...)
+			productionVariants = append(productionVariants, describeEndpointConfigOutput.ShadowProductionVariants...)
+			for _, productionVariant := range productionVariants {
+				if productionVariant.ModelName != nil && modelNames.contains(*productionVariant.ModelName) {
+					endpointNames = append(endpointNames, aws.ToString(endpoint.EndpointName))
+					continue ENDPOINT
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return endpointNames, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSageMakerModelNames returns the names of the SageMaker models whose VPC
+// configuration has a subnet in subnetIds.
+func listSageMakerModelNames(ctx context.Context, client *sagemaker.Client, subnetIds stringSet) ([]string, error) {
+	input := sagemaker.ListModelsInput{}
+	var modelNames []string
+	for {
+		output, err := client.ListModels(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+	MODEL:
+		for _, model := range output.Models {
+			describeModelOutput, err := client.DescribeModel(ctx, &sagemaker.DescribeModelInput{
+				ModelName: model.ModelName,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if describeModelOutput.VpcConfig == nil {
+				continue
+			}
+			for _, subnetId := range describeModelOutput.VpcConfig.Subnets {
+				if subnetIds.contains(subnetId) {
+					modelNames = append(modelNames, aws.ToString(model.ModelName))
+					continue MODEL
+				}
+			}
+		}
+		if output.NextToken == nil {
+			return modelNames, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// listSageMakerNotebookInstances returns the SageMaker notebook instances in
+// one of subnetIds.
+func listSageMakerNotebookInstances(ctx context.Context, client *sagemaker.Client, subnetIds stringSet) ([]*sagemaker.DescribeNotebookInstanceOutput, error) {
+	input := sagemaker.ListNotebookInstancesInput{}
+	var notebookInstances []*sagemaker.DescribeNotebookInstanceOutput
+	for {
+		output, err := client.ListNotebookInstances(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		for _, notebookInstanceSummary := range output.NotebookInstances {
+			notebookInstance, err := client.DescribeNotebookInstance(ctx, &sagemaker.DescribeNotebookInstanceInput{
+				NotebookInstanceName: notebookInstanceSummary.NotebookInstanceName,
+			})
+			if err != nil {
+				return nil, err
+			}
+			if notebookInstance.SubnetId != nil && subnetIds.contains(*notebookInstance.SubnetId) {
+				notebookInstances = append(notebookInstances, notebookInstance)
+			}
+		}
+		if output.NextToken == nil {
+			return notebookInstances, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func listSageMakerSpaces(ctx context.Context, client *sagemaker.Client, domainId string) ([]types.SpaceDetails, error) {
+	input := sagemaker.ListSpacesInput{
+		DomainIdEquals: aws.String(domainId),
+	}
+	var spaces []types.SpaceDetails
+	for {
+		output, err := client.ListSpaces(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		spaces = append(spaces, output.Spaces...)
+		if output.NextToken == nil {
+			return spaces, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func listSageMakerUserProfiles(ctx context.Context, client *sagemaker.Client, domainId string) ([]types.UserProfileDetails, error) {
+	input := sagemaker.ListUserProfilesInput{
+		DomainIdEquals: aws.String(domainId),
+	}
+	var userProfiles []types.UserProfileDetails
+	for {
+		output, err := client.ListUserProfiles(ctx, &input)
+		if err != nil {
+			return nil, err
+		}
+		userProfiles = append(userProfiles, output.UserProfiles...)
+		if output.NextToken == nil {
+			return userProfiles, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+func sageMakerNotebookInstanceNames(notebookInstances []*sagemaker.DescribeNotebookInstanceOutput) []string {
+	notebookInstanceNames := make([]string, 0, len(notebookInstances))
+	for _, notebookInstance := range notebookInstances {
+		if notebookInstance.NotebookInstanceName != nil {
+			notebookInstanceNames = append(notebookInstanceNames, *notebookInstance.NotebookInstanceName)
+		}
+	}
+	return notebookInstanceNames
+}
+
+// waitForSageMakerResourcesDeleted waits until list returns no remaining
+// resources. description describes the resources in errors and operation is
+// the API operation called by list.
+func waitForSageMakerResourcesDeleted(ctx context.Context, description, operation string, list func(context.Context) ([]string, error)) error {
+	var remaining []string
+	err := poll(ctx, sageMakerPollInterval, sageMakerWaiterMaxDuration, func(ctx context.Context) (bool, error) {
+		var err error
+		remaining, err = list(ctx)
+		if err != nil {
+			return false, err
+		}
+		log.Info().
+			Strs("remaining", remaining).
+			Msg(operation)
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		err = fmt.Errorf("timed out waiting for SageMaker %s to be deleted: %s", description, strings.Join(remaining, ", "))
+	}
+	return err
+}
@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/rs/zerolog/log"
@@ -51,9 +55,22 @@ func deleteSecurityGroups(ctx context.Context, client *ec2.Client, vpcId string,
 	return
 }
 
-func listNonDefaultSecurityGroups(ctx context.Context, client *ec2.Client, vpcId string) ([]types.SecurityGroup, error) {
+// excludeSecurityGroups splits securityGroups into those whose IDs are not in
+// excludedSecurityGroupIds and those whose IDs are.
+func excludeSecurityGroups(securityGroups []types.SecurityGroup, excludedSecurityGroupIds stringSet) (includedSecurityGroups, excludedSecurityGroups []types.SecurityGroup) {
+	for _, securityGroup := range securityGroups {
+		if securityGroup.GroupId != nil && excludedSecurityGroupIds.contains(*securityGroup.GroupId) {
+			excludedSecurityGroups = append(excludedSecurityGroups, securityGroup)
+		} else {
+			includedSecurityGroups = append(includedSecurityGroups, securityGroup)
+		}
+	}
+	return
+}
+
+func listNonDefaultSecurityGroups(ctx context.Context, client *ec2.Client, filters []types.Filter) ([]types.SecurityGroup, error) {
 	input := ec2.DescribeSecurityGroupsInput{
-		Filters: ec2VpcFilter(vpcId),
+		Filters: filters,
 	}
 	var securityGroups []types.SecurityGroup
 	for {
@@ -74,6 +91,19 @@ func listNonDefaultSecurityGroups(ctx context.Context, client *ec2.Client, vpcId
 	}
 }
 
+// partitionSharedSecurityGroups splits securityGroups into those that may be deleted and
+// those that are shared with the cluster clusterName and must be kept.
+func partitionSharedSecurityGroups(securityGroups []types.SecurityGroup, clusterName string) (ownedSecurityGroups, sharedSecurityGroups []types.SecurityGroup) {
+	for _, securityGroup := range securityGroups {
+		if isSharedWithCluster(securityGroup.Tags, clusterName) {
+			sharedSecurityGroups = append(sharedSecurityGroups, securityGroup)
+		} else {
+			ownedSecurityGroups = append(ownedSecurityGroups, securityGroup)
+		}
+	}
+	return
+}
+
 func securityGroupIds(securityGroups []types.SecurityGroup) []string {
 	securityGroupIds := make([]string, 0, len(securityGroups))
 	for _, securityGroup := range securityGroups {
@@ -83,3 +113,31 @@ func securityGroupIds(securityGroups []types.SecurityGroup) []string {
 	}
 	return securityGroupIds
 }
+
+// waitForSecurityGroupsDeleted waits, for up to timeout, until the
+// SecurityGroups groupIds have been deleted. It is used to wait for services
+// to delete the SecurityGroups that they create in the VPC.
+func waitForSecurityGroupsDeleted(ctx context.Context, client *ec2.Client, groupIds []string, timeout time.Duration) error {
+	filters := []types.Filter{
+		{
+			Name:   aws.String("group-id"),
+			Values: groupIds,
+		},
+	}
+	var remaining []string
+	err := poll(ctx, securityGroupPollInterval, timeout, func(ctx context.Context) (bool, error) {
+		securityGroups, err := listNonDefaultSecurityGroups(ctx, client, filters)
+		if err != nil {
+			return false, err
+		}
+		remaining = securityGroupIds(securityGroups)
+		log.Info().
+			Strs("securityGroupIds", remaining).
+			Msg("listNonDefaultSecurityGroups")
+		return len(remaining) == 0, nil
+	})
+	if err == errPollTimeout {
+		return fmt.Errorf("timed out waiting for SecurityGroups to be deleted: %s", strings.Join(remaining, ", "))
+	}
+	return err
+}
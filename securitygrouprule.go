@@ -78,6 +78,57 @@ func listSecurityGroupRules(ctx context.Context, client *ec2.Client, groupId str
 	}
 }
 
+// revokeSecurityGroupReferences revokes all rules in other SecurityGroups that
+// reference the SecurityGroup with ID groupId, which otherwise prevent it from
+// being deleted.
+func revokeSecurityGroupReferences(ctx context.Context, client *ec2.Client, groupId string) (errs error) {
+	var referencingSecurityGroups []types.SecurityGroup
+	for _, filterName := range []string{"ip-permission.group-id", "egress.ip-permission.group-id"} {
+		securityGroups, err := listNonDefaultSecurityGroups(ctx, client, []types.Filter{
+			{
+				Name:   aws.String(filterName),
+				Values: []string{groupId},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		referencingSecurityGroups = append(referencingSecurityGroups, securityGroups...)
+	}
+
+	seenGroupIds := newStringSet()
+	for _, referencingGroupId := range securityGroupIds(referencingSecurityGroups) {
+		if referencingGroupId == groupId || seenGroupIds.contains(referencingGroupId) {
+			continue
+		}
+		seenGroupIds[referencingGroupId] = struct{}{}
+
+		securityGroupRules, err := listSecurityGroupRules(ctx, client, referencingGroupId)
+		if err != nil {
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		var referencingSecurityGroupRules []types.SecurityGroupRule
+		for _, securityGroupRule := range securityGroupRules {
+			referencedGroupInfo := securityGroupRule.ReferencedGroupInfo
+			if referencedGroupInfo == nil || referencedGroupInfo.GroupId == nil || *referencedGroupInfo.GroupId != groupId {
+				continue
+			}
+			referencingSecurityGroupRules = append(referencingSecurityGroupRules, securityGroupRule)
+		}
+		if len(referencingSecurityGroupRules) > 0 {
+			err := deleteSecurityGroupRules(ctx, client, referencingGroupId, referencingSecurityGroupRules)
+			log.Err(err).
+				Str("groupId", referencingGroupId).
+				Str("referencedGroupId", groupId).
+				Strs("securityGroupRuleIds", securityGroupRuleIds(referencingSecurityGroupRules)).
+				Msg("deleteSecurityGroupRules")
+			errs = multierr.Append(errs, err)
+		}
+	}
+	return
+}
+
 func securityGroupRuleIds(securityGroupRules []types.SecurityGroupRule) []string {
 	securityGroupRuleIds := make([]string, 0, len(securityGroupRules))
 	for _, securityGroupRule := range securityGroupRules {
@@ -53,6 +53,46 @@ func listSubnets(ctx context.Context, client *ec2.Client, vpcId string) ([]types
 	}
 }
 
+// excludeSubnets splits subnets into those whose IDs are not in
+// excludedSubnetIds and those whose IDs are.
+func excludeSubnets(subnets []types.Subnet, excludedSubnetIds stringSet) (includedSubnets, excludedSubnets []types.Subnet) {
+	for _, subnet := range subnets {
+		if subnet.SubnetId != nil && excludedSubnetIds.contains(*subnet.SubnetId) {
+			excludedSubnets = append(excludedSubnets, subnet)
+		} else {
+			includedSubnets = append(includedSubnets, subnet)
+		}
+	}
+	return
+}
+
+// partitionSharedSubnets splits subnets into those that may be deleted and
+// those that are shared with the cluster clusterName and must be kept.
+func partitionSharedSubnets(subnets []types.Subnet, clusterName string) (ownedSubnets, sharedSubnets []types.Subnet) {
+	for _, subnet := range subnets {
+		if isSharedWithCluster(subnet.Tags, clusterName) {
+			sharedSubnets = append(sharedSubnets, subnet)
+		} else {
+			ownedSubnets = append(ownedSubnets, subnet)
+		}
+	}
+	return
+}
+
+// subnetAvailabilityZones returns the distinct Availability Zones of subnets.
+func subnetAvailabilityZones(subnets []types.Subnet) []string {
+	var availabilityZones []string
+	seenAvailabilityZones := newStringSet()
+	for _, subnet := range subnets {
+		if subnet.AvailabilityZone == nil || seenAvailabilityZones.contains(*subnet.AvailabilityZone) {
+			continue
+		}
+		seenAvailabilityZones[*subnet.AvailabilityZone] = struct{}{}
+		availabilityZones = append(availabilityZones, *subnet.AvailabilityZone)
+	}
+	return availabilityZones
+}
+
 func subnetIds(subnets []types.Subnet) []string {
 	subnetIds := make([]string, 0, len(subnets))
 	for _, subnet := range subnets {
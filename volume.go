@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/multierr"
+)
+
+// deleteVolumes deletes volumes. If snapshot is true then a snapshot of each
+// Volume is started first, and the Volume is only deleted if the snapshot was
+// successfully started.
+func deleteVolumes(ctx context.Context, client *ec2.Client, volumes []types.Volume, snapshot bool) (errs error) {
+	for _, volume := range volumes {
+		if volume.VolumeId == nil {
+			continue
+		}
+
+		// Snapshot the Volume. The snapshot completes asynchronously, even
+		// after the Volume is deleted.
+		if snapshot {
+			output, err := client.CreateSnapshot(ctx, &ec2.CreateSnapshotInput{
+				VolumeId:          volume.VolumeId,
+				Description:       aws.String(fmt.Sprintf("Created by aws-delete-vpc from %s", *volume.VolumeId)),
+				TagSpecifications: volumeSnapshotTagSpecifications(volume),
+			})
+			log.Err(err).
+				Str("VolumeId", *volume.VolumeId).
+				Msg("CreateSnapshot")
+			errs = multierr.Append(errs, err)
+			if err != nil {
+				continue
+			}
+			log.Info().
+				Str("VolumeId", *volume.VolumeId).
+				Str("SnapshotId", aws.ToString(output.SnapshotId)).
+				Msg("CreateSnapshot")
+		}
+
+		_, err := client.DeleteVolume(ctx, &ec2.DeleteVolumeInput{
+			VolumeId: volume.VolumeId,
+		})
+		log.Err(err).
+			Str("VolumeId", *volume.VolumeId).
+			Msg("DeleteVolume")
+		errs = multierr.Append(errs, err)
+	}
+	return
+}
+
+// listClusterVolumes returns the unattached Volumes in availabilityZones that
+// were created for the cluster clusterName, either by the in-tree EBS
+// provisioner or by the EBS CSI driver. Volumes are not associated with a VPC,
+// so availabilityZones should be those of the cluster's VPC's Subnets.
+func listClusterVolumes(ctx context.Context, client *ec2.Client, clusterName string, availabilityZones []string) ([]types.Volume, error) {
+	if len(availabilityZones) == 0 {
+		return nil, nil
+	}
+	availabilityZoneFilter := types.Filter{
+		Name:   aws.String("availability-zone"),
+		Values: availabilityZones,
+	}
+	availableFilter := types.Filter{
+		Name:   aws.String("status"),
+		Values: []string{string(types.VolumeStateAvailable)},
+	}
+	filterSets := [][]types.Filter{
+		append([]types.Filter{availabilityZoneFilter, availableFilter}, ec2ClusterTagFilter(clusterName)...),
+		{
+			availabilityZoneFilter,
+			availableFilter,
+			{
+				Name:   aws.String("tag:KubernetesCluster"),
+				Values: []string{clusterName},
+			},
+		},
+	}
+
+	var volumes []types.Volume
+	seenVolumeIds := newStringSet()
+	for _, filters := range filterSets {
+		input := ec2.DescribeVolumesInput{
+			Filters: filters,
+		}
+		for {
+			output, err := client.DescribeVolumes(ctx, &input)
+			if err != nil {
+				return nil, err
+			}
+			for _, volume := range output.Volumes {
+				if volume.VolumeId == nil || seenVolumeIds.contains(*volume.VolumeId) {
+					continue
+				}
+				seenVolumeIds[*volume.VolumeId] = struct{}{}
+				volumes = append(volumes, volume)
+			}
+			if output.NextToken == nil {
+				break
+			}
+			input.NextToken = output.NextToken
+		}
+	}
+	return volumes, nil
+}
+
+func volumeIds(volumes []types.Volume) []string {
+	volumeIds := make([]string, 0, len(volumes))
+	for _, volume := range volumes {
+		if volume.VolumeId != nil {
+			volumeIds = append(volumeIds, *volume.VolumeId)
+		}
+	}
+	return volumeIds
+}
+
+// volumeSnapshotTagSpecifications returns TagSpecifications that copy volume's
+// tags to its snapshot, so that the snapshot can be traced back to its
+// PersistentVolumeClaim.
+func volumeSnapshotTagSpecifications(volume types.Volume) []types.TagSpecification {
+	// Tags with the reserved aws: prefix cannot be set.
+	var tags []types.Tag
+	for _, tag := range volume.Tags {
+		if tag.Key == nil || strings.HasPrefix(*tag.Key, "aws:") {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return []types.TagSpecification{
+		{
+			ResourceType: types.ResourceTypeSnapshot,
+			Tags:         tags,
+		},
+	}
+}
@@ -5,30 +5,103 @@ import (
 	"errors"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/apigateway"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go-v2/service/apprunner"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	autoscalingtypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/batch"
+	"github.com/aws/aws-sdk-go-v2/service/databasemigrationservice"
+	"github.com/aws/aws-sdk-go-v2/service/directoryservice"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/efs"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/elasticache"
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/emr"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kafka"
+	"github.com/aws/aws-sdk-go-v2/service/kafkaconnect"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/memorydb"
+	"github.com/aws/aws-sdk-go-v2/service/mq"
+	"github.com/aws/aws-sdk-go-v2/service/mwaa"
+	"github.com/aws/aws-sdk-go-v2/service/opensearch"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/redshift"
+	"github.com/aws/aws-sdk-go-v2/service/redshiftserverless"
+	"github.com/aws/aws-sdk-go-v2/service/sagemaker"
 	"github.com/aws/smithy-go"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/multierr"
 )
 
 type clients struct {
-	autoscaling          *autoscaling.Client
-	ec2                  *ec2.Client
-	elasticloadbalancing *elasticloadbalancing.Client
-	eks                  *eks.Client
+	apigateway               *apigateway.Client
+	apigatewayv2             *apigatewayv2.Client
+	apprunner                *apprunner.Client
+	autoscaling              *autoscaling.Client
+	batch                    *batch.Client
+	databasemigrationservice *databasemigrationservice.Client
+	directoryservice         *directoryservice.Client
+	ec2                      *ec2.Client
+	ecs                      *ecs.Client
+	efs                      *efs.Client
+	elasticache              *elasticache.Client
+	elasticloadbalancing     *elasticloadbalancing.Client
+	elasticloadbalancingv2   *elasticloadbalancingv2.Client
+	emr                      *emr.Client
+	eks                      *eks.Client
+	iam                      *iam.Client
+	kafka                    *kafka.Client
+	kafkaconnect             *kafkaconnect.Client
+	lambda                   *lambda.Client
+	memorydb                 *memorydb.Client
+	mq                       *mq.Client
+	mwaa                     *mwaa.Client
+	opensearch               *opensearch.Client
+	rds                      *rds.Client
+	redshift                 *redshift.Client
+	redshiftserverless       *redshiftserverless.Client
+	sagemaker                *sagemaker.Client
+
+	// region is the region of all of the clients.
+	region string
 }
 
 func newClientsFromConfig(config aws.Config) *clients {
 	return &clients{
-		autoscaling:          autoscaling.NewFromConfig(config),
-		ec2:                  ec2.NewFromConfig(config),
-		elasticloadbalancing: elasticloadbalancing.NewFromConfig(config),
-		eks:                  eks.NewFromConfig(config),
+		apigateway:               apigateway.NewFromConfig(config),
+		apigatewayv2:             apigatewayv2.NewFromConfig(config),
+		apprunner:                apprunner.NewFromConfig(config),
+		autoscaling:              autoscaling.NewFromConfig(config),
+		batch:                    batch.NewFromConfig(config),
+		databasemigrationservice: databasemigrationservice.NewFromConfig(config),
+		directoryservice:         directoryservice.NewFromConfig(config),
+		ec2:                      ec2.NewFromConfig(config),
+		ecs:                      ecs.NewFromConfig(config),
+		efs:                      efs.NewFromConfig(config),
+		elasticache:              elasticache.NewFromConfig(config),
+		elasticloadbalancing:     elasticloadbalancing.NewFromConfig(config),
+		elasticloadbalancingv2:   elasticloadbalancingv2.NewFromConfig(config),
+		emr:                      emr.NewFromConfig(config),
+		eks:                      eks.NewFromConfig(config),
+		iam:                      iam.NewFromConfig(config),
+		kafka:                    kafka.NewFromConfig(config),
+		kafkaconnect:             kafkaconnect.NewFromConfig(config),
+		lambda:                   lambda.NewFromConfig(config),
+		memorydb:                 memorydb.NewFromConfig(config),
+		mq:                       mq.NewFromConfig(config),
+		mwaa:                     mwaa.NewFromConfig(config),
+		opensearch:               opensearch.NewFromConfig(config),
+		rds:                      rds.NewFromConfig(config),
+		redshift:                 redshift.NewFromConfig(config),
+		redshiftserverless:       redshiftserverless.NewFromConfig(config),
+		sagemaker:                sagemaker.NewFromConfig(config),
+		region:                   config.Region,
 	}
 }
 
@@ -45,7 +118,33 @@ func deleteVpc(ctx context.Context, client *ec2.Client, vpcId string) error {
 
 // deleteVpcDependencies tries to delete all dependencies of the VPC with ID
 // vpcId. It accumulates errors.
-func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, vpcId string, resources stringSet, autoScalingFilters []autoscalingtypes.Filter) (errs error) {
+func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, vpcId string, resources stringSet, autoScalingFilters []autoscalingtypes.Filter, opts *options) (errs error) {
+	if resources.contains("Clusters") {
+		if clusters, err := listClusters(ctx, clients.eks, vpcId); err != nil {
+			log.Err(err).Msg("listClusters")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("clusterNames", clusterNames(clusters)).
+				Msg("listClusters")
+			if len(clusters) > 0 {
+				err := deleteClusters(ctx, clients.eks, clusters)
+				log.Err(err).
+					Strs("clusterNames", clusterNames(clusters)).
+					Msg("deleteClusters")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	if resources.contains("Karpenter") && clusterName != "" {
+		err := deleteKarpenterResources(ctx, clients, clusterName, vpcId, opts)
+		log.Err(err).
+			Str("clusterName", clusterName).
+			Msg("deleteKarpenterResources")
+		errs = multierr.Append(errs, err)
+	}
+
 	if resources.contains("VpcPeeringConnections") {
 		if vpcPeeringConnections, err := listVpcPeeringConnections(ctx, clients.ec2, vpcId); err != nil {
 			log.Err(err).Msg("listVpcPeeringConnections")
@@ -64,7 +163,39 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 		}
 	}
 
+	if resources.contains("ApiGatewayVpcLinks") {
+		err := deleteApiGatewayVpcLinksInVpc(ctx, clients.apigateway, clients.apigatewayv2, clients.elasticloadbalancingv2, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteApiGatewayVpcLinksInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("AppRunnerVpcConnectors") {
+		err := deleteAppRunnerVpcConnectorsInVpc(ctx, clients.apprunner, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteAppRunnerVpcConnectorsInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("MwaaEnvironments") {
+		err := deleteMwaaEnvironmentsInVpc(ctx, clients.mwaa, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteMwaaEnvironmentsInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
 	if resources.contains("LoadBalancers") {
+		if clusterName != "" {
+			err := deleteKubernetesLoadBalancers(ctx, clients, clusterName)
+			log.Err(err).
+				Str("clusterName", clusterName).
+				Msg("deleteKubernetesLoadBalancers")
+			errs = multierr.Append(errs, err)
+		}
+
 		if loadBalancerDescriptions, err := listLoadBalancers(ctx, clients.elasticloadbalancing, vpcId); err != nil {
 			log.Err(err).Msg("listLoadBalancers")
 			errs = multierr.Append(errs, err)
@@ -96,18 +227,74 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 					Strs("autoScalingGroupNames", autoScalingGroupNames(autoScalingGroups)).
 					Msg("listAutoScalingGroups")
 				if len(autoScalingGroups) > 0 {
-					err := deleteAutoScalingGroups(ctx, clients.autoscaling, clients.ec2, autoScalingGroups)
+					err := deleteAutoScalingGroups(ctx, clients.autoscaling, autoScalingGroups, opts.forceDeleteAutoScalingGroups)
 					log.Err(err).
 						Strs("autoScalingGroupNames", autoScalingGroupNames(autoScalingGroups)).
 						Msg("deleteAutoScalingGroups")
 					errs = multierr.Append(errs, err)
+					opts.deletedAutoScalingGroups = append(opts.deletedAutoScalingGroups, autoScalingGroups...)
 				}
 			}
 		}
+
+		// Delete the LaunchTemplates of the AutoScalingGroups deleted in this
+		// and earlier tries, as they are no longer listed once deleted.
+		if opts.deleteLaunchTemplates && len(opts.deletedAutoScalingGroups) > 0 {
+			err := deleteAutoScalingGroupLaunchTemplates(ctx, clients, opts.deletedAutoScalingGroups)
+			log.Err(err).
+				Strs("autoScalingGroupNames", autoScalingGroupNames(opts.deletedAutoScalingGroups)).
+				Msg("deleteAutoScalingGroupLaunchTemplates")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if resources.contains("Fleets") {
+		if subnets, err := listSubnets(ctx, clients.ec2, vpcId); err != nil {
+			log.Err(err).
+				Msg("listSubnets")
+			errs = multierr.Append(errs, err)
+		} else {
+			err := deleteFleetsInSubnets(ctx, clients.ec2, newStringSet(subnetIds(subnets)...))
+			log.Err(err).
+				Strs("subnetIds", subnetIds(subnets)).
+				Msg("deleteFleetsInSubnets")
+			errs = multierr.Append(errs, err)
+		}
+	}
+
+	if resources.contains("EmrClusters") {
+		if subnets, err := listSubnets(ctx, clients.ec2, vpcId); err != nil {
+			log.Err(err).
+				Msg("listSubnets")
+			errs = multierr.Append(errs, err)
+		} else if clusters, err := listEmrClusters(ctx, clients.emr, newStringSet(subnetIds(subnets)...)); err != nil {
+			log.Err(err).
+				Msg("listEmrClusters")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("clusterIds", emrClusterIds(clusters)).
+				Msg("listEmrClusters")
+			if len(clusters) > 0 {
+				err := terminateEmrClusters(ctx, clients.emr, clusters, opts.force)
+				log.Err(err).
+					Strs("clusterIds", emrClusterIds(clusters)).
+					Msg("terminateEmrClusters")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
+	if resources.contains("BatchComputeEnvironments") {
+		err := deleteBatchComputeEnvironmentsInVpc(ctx, clients.batch, clients.ec2, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteBatchComputeEnvironmentsInVpc")
+		errs = multierr.Append(errs, err)
 	}
 
 	if resources.contains("Reservations") {
-		if reservations, err := listReservations(ctx, clients.ec2, vpcId); err != nil {
+		if reservations, err := listReservations(ctx, clients.ec2, ec2VpcFilter(vpcId)); err != nil {
 			log.Err(err).Msg("listReservations")
 			errs = multierr.Append(errs, err)
 		} else {
@@ -115,7 +302,7 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 				Strs("instanceIds", instanceIds(reservations)).
 				Msg("listReservations")
 			if len(reservations) > 0 {
-				err := terminateInstancesInReservations(ctx, clients.ec2, reservations)
+				err := terminateInstancesInReservations(ctx, clients.ec2, reservations, opts.force)
 				log.Err(err).
 					Strs("instanceIds", instanceIds(reservations)).
 					Msg("terminateInstancesInReservations")
@@ -124,6 +311,131 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 		}
 	}
 
+	if resources.contains("Databases") {
+		err := deleteDatabasesInVpc(ctx, clients.rds, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteDatabasesInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("CacheClusters") {
+		err := deleteElastiCacheClustersInVpc(ctx, clients.elasticache, vpcId, opts.finalSnapshotPrefix)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteElastiCacheClustersInVpc")
+		errs = multierr.Append(errs, err)
+
+		err = deleteMemoryDBClustersInVpc(ctx, clients.memorydb, vpcId, opts.finalSnapshotPrefix)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteMemoryDBClustersInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("SageMakerDomains") {
+		err := deleteSageMakerInVpc(ctx, clients.sagemaker, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteSageMakerInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("EfsMountTargets") {
+		err := deleteEfsInVpc(ctx, clients.efs, clients.ec2, clusterName, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteEfsInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("LambdaFunctions") {
+		err := deleteLambdaFunctionsInVpc(ctx, clients.lambda, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteLambdaFunctionsInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("EcsServices") {
+		err := deleteEcsServicesInVpc(ctx, clients.ecs, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteEcsServicesInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("MskClusters") {
+		err := deleteMskInVpc(ctx, clients.kafka, clients.kafkaconnect, clients.ec2, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteMskInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("OpenSearchDomains") {
+		err := deleteOpenSearchDomainsInVpc(ctx, clients.opensearch, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteOpenSearchDomainsInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("RedshiftClusters") {
+		err := deleteRedshiftInVpc(ctx, clients.redshift, clients.redshiftserverless, clients.ec2, vpcId, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteRedshiftInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("ReplicationInstances") {
+		err := deleteReplicationInstancesInVpc(ctx, clients.databasemigrationservice, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteReplicationInstancesInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("MqBrokers") {
+		err := deleteMqBrokersInVpc(ctx, clients.mq, clients.ec2, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteMqBrokersInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("Directories") {
+		err := deleteDirectoriesInVpc(ctx, clients.directoryservice, clients.ec2, vpcId)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("deleteDirectoriesInVpc")
+		errs = multierr.Append(errs, err)
+	}
+
+	if resources.contains("Volumes") && clusterName != "" {
+		if subnets, err := listSubnets(ctx, clients.ec2, vpcId); err != nil {
+			log.Err(err).
+				Msg("listSubnets")
+			errs = multierr.Append(errs, err)
+		} else if volumes, err := listClusterVolumes(ctx, clients.ec2, clusterName, subnetAvailabilityZones(subnets)); err != nil {
+			log.Err(err).
+				Msg("listClusterVolumes")
+			errs = multierr.Append(errs, err)
+		} else {
+			log.Info().
+				Strs("volumeIds", volumeIds(volumes)).
+				Msg("listClusterVolumes")
+			if len(volumes) > 0 {
+				err := deleteVolumes(ctx, clients.ec2, volumes, opts.snapshotVolumes)
+				log.Err(err).
+					Strs("volumeIds", volumeIds(volumes)).
+					Msg("deleteVolumes")
+				errs = multierr.Append(errs, err)
+			}
+		}
+	}
+
 	if resources.contains("NetworkAcls") {
 		if networkAcls, err := listNonDefaultNetworkAcls(ctx, clients.ec2, vpcId); err != nil {
 			log.Err(err).
@@ -143,8 +455,35 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 		}
 	}
 
+	if len(opts.pendingNetworkInterfaceIds) > 0 {
+		err := waitForPendingNetworkInterfacesDeleted(ctx, clients.ec2, opts)
+		log.Err(err).
+			Str("vpcId", vpcId).
+			Msg("waitForPendingNetworkInterfacesDeleted")
+		errs = multierr.Append(errs, err)
+	}
+
 	if resources.contains("NetworkInterfaces") {
-		if networkInterfaces, err := listNetworkInterfaces(ctx, clients.ec2, vpcId); err != nil {
+		if clusterName != "" {
+			if networkInterfaces, err := listCiliumNetworkInterfaces(ctx, clients.ec2, clusterName); err != nil {
+				log.Err(err).
+					Msg("listCiliumNetworkInterfaces")
+				errs = multierr.Append(errs, err)
+			} else {
+				log.Info().
+					Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+					Msg("listCiliumNetworkInterfaces")
+				if len(networkInterfaces) > 0 {
+					err := deleteCiliumNetworkInterfaces(ctx, clients.ec2, networkInterfaces)
+					log.Err(err).
+						Strs("networkInterfaceIds", networkInterfaceIds(networkInterfaces)).
+						Msg("deleteCiliumNetworkInterfaces")
+					errs = multierr.Append(errs, err)
+				}
+			}
+		}
+
+		if networkInterfaces, err := listNetworkInterfaces(ctx, clients.ec2, ec2VpcFilter(vpcId)); err != nil {
 			log.Err(err).
 				Msg("listNetworkInterfaces")
 			errs = multierr.Append(errs, err)
@@ -204,6 +543,14 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 			log.Err(err).
 				Msg("listSubnets")
 		} else {
+			subnets, sharedSubnets := excludeSubnets(subnets, opts.sharedResourceIds)
+			if len(sharedSubnets) > 0 {
+				err := untagSharedResources(ctx, clients.ec2, clusterName, subnetIds(sharedSubnets))
+				log.Err(err).
+					Strs("subnetIds", subnetIds(sharedSubnets)).
+					Msg("skipping shared Subnets")
+				errs = multierr.Append(errs, err)
+			}
 			log.Info().
 				Strs("subnetIds", subnetIds(subnets)).
 				Msg("listSubnets")
@@ -218,10 +565,26 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 	}
 
 	if resources.contains("SecurityGroups") {
-		if securityGroups, err := listNonDefaultSecurityGroups(ctx, clients.ec2, vpcId); err != nil {
+		if clusterName != "" {
+			err := deleteKubernetesLoadBalancerSecurityGroups(ctx, clients, clusterName)
+			log.Err(err).
+				Str("clusterName", clusterName).
+				Msg("deleteKubernetesLoadBalancerSecurityGroups")
+			errs = multierr.Append(errs, err)
+		}
+
+		if securityGroups, err := listNonDefaultSecurityGroups(ctx, clients.ec2, ec2VpcFilter(vpcId)); err != nil {
 			log.Err(err).
 				Msg("listNonDefaultSecurityGroups")
 		} else {
+			securityGroups, sharedSecurityGroups := excludeSecurityGroups(securityGroups, opts.sharedResourceIds)
+			if len(sharedSecurityGroups) > 0 {
+				err := untagSharedResources(ctx, clients.ec2, clusterName, securityGroupIds(sharedSecurityGroups))
+				log.Err(err).
+					Strs("securityGroupIds", securityGroupIds(sharedSecurityGroups)).
+					Msg("skipping shared SecurityGroups")
+				errs = multierr.Append(errs, err)
+			}
 			log.Info().
 				Strs("securityGroupIds", securityGroupIds(securityGroups)).
 				Msg("listNonDefaultSecurityGroups")
@@ -240,6 +603,14 @@ func deleteVpcDependencies(ctx context.Context, clients *clients, clusterName, v
 			log.Err(err).
 				Msg("listRouteTables")
 		} else {
+			routeTables, sharedRouteTables := excludeRouteTables(routeTables, opts.sharedResourceIds)
+			if len(sharedRouteTables) > 0 {
+				err := untagSharedResources(ctx, clients.ec2, clusterName, routeTableIds(sharedRouteTables))
+				log.Err(err).
+					Strs("routeTableIds", routeTableIds(sharedRouteTables)).
+					Msg("skipping shared RouteTables")
+				errs = multierr.Append(errs, err)
+			}
 			log.Info().
 				Strs("routeTableIds", routeTableIds(routeTables)).
 				Msg("listRouteTables")
@@ -357,6 +728,17 @@ func tryDeleteVpc(ctx context.Context, client *ec2.Client, vpcId string) (bool,
 	return false, err
 }
 
+// vpcExists returns whether the VPC with ID vpcId exists.
+func vpcExists(ctx context.Context, client *ec2.Client, vpcId string) (bool, error) {
+	output, err := client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{
+		Filters: ec2VpcFilter(vpcId),
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(output.Vpcs) > 0, nil
+}
+
 func vpcIds(vpcs []ec2types.Vpc) []string {
 	vpcIds := make([]string, 0, len(vpcs))
 	for _, vpc := range vpcs {